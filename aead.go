@@ -0,0 +1,67 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package keyfile
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// A Cipher identifies the AEAD construction used to encrypt a secret. The
+// zero value is AES256GCM.
+type Cipher byte
+
+const (
+	AES256GCM        Cipher = 0 // AES-256 in Galois Counter Mode (the default)
+	ChaCha20Poly1305 Cipher = 1 // ChaCha20-Poly1305, as defined by RFC 8439
+)
+
+func (c Cipher) String() string {
+	switch c {
+	case AES256GCM:
+		return "aes-256-gcm"
+	case ChaCha20Poly1305:
+		return "chacha20-poly1305"
+	default:
+		return fmt.Sprintf("Cipher(%d)", byte(c))
+	}
+}
+
+// check reports an error if c is not a known cipher.
+func (c Cipher) check() error {
+	if c != AES256GCM && c != ChaCha20Poly1305 {
+		return fmt.Errorf("unknown cipher %d", byte(c))
+	}
+	return nil
+}
+
+// An Option configures a File created by New.
+type Option func(*File)
+
+// WithCipher selects the AEAD construction a File uses when a secret is
+// stored by Set. It panics if c is not a known cipher.
+func WithCipher(c Cipher) Option {
+	if err := c.check(); err != nil {
+		panic(err)
+	}
+	return func(f *File) { f.cipher = c }
+}
+
+// newAEAD returns a cipher.AEAD of the given type using the derived key.
+func newAEAD(c Cipher, ckey []byte) (cipher.AEAD, error) {
+	switch c {
+	case AES256GCM:
+		blk, err := aes.NewCipher(ckey)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(blk)
+	case ChaCha20Poly1305:
+		return chacha20poly1305.New(ckey)
+	default:
+		return nil, c.check()
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package keyfile
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An Agent holds decrypted keys in memory and serves them to clients, so that
+// a passphrase need only be entered once per session. Keys are identified by
+// the absolute path of the keyfile they were loaded from, and an Agent serves
+// only keys that were explicitly loaded into it.
+//
+// An Agent is safe for concurrent use by multiple goroutines.
+//
+// Clients request keys with AgentKey. The protocol is: the client sends the
+// path of the keyfile followed by a newline; the agent replies with a status
+// byte followed by the key (on success) or an error message, then closes the
+// connection.
+type Agent struct {
+	ttl time.Duration
+
+	mu   sync.Mutex
+	keys map[string]*agentKey
+}
+
+type agentKey struct {
+	key   []byte
+	timer *time.Timer // nil if there is no TTL
+}
+
+// Agent reply status codes.
+const (
+	agentOK    = 0 // success, followed by the key
+	agentNoKey = 1 // the requested key is not loaded
+	agentError = 2 // other error, followed by a message
+)
+
+// agentTimeout is the time allowed for a client to send a request and receive
+// its reply.
+const agentTimeout = 10 * time.Second
+
+// NewAgent constructs a new empty *Agent. If ttl > 0, each key is forgotten
+// once ttl has elapsed after it was loaded; otherwise keys are held until they
+// are forgotten explicitly or the agent is closed.
+func NewAgent(ttl time.Duration) *Agent {
+	return &Agent{ttl: ttl, keys: make(map[string]*agentKey)}
+}
+
+// Load loads and decrypts the keyfile at path as LoadKey does, and adds its
+// key to a, replacing any key previously loaded from the same path.
+func (a *Agent) Load(path string, pf func() (string, error)) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	key, err := LoadKey(path, pf)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.forgetLocked(path)
+	ak := &agentKey{key: key}
+	if a.ttl > 0 {
+		ak.timer = time.AfterFunc(a.ttl, func() {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			if a.keys[path] == ak {
+				a.forgetLocked(path)
+			}
+		})
+	}
+	a.keys[path] = ak
+	return nil
+}
+
+// Get returns a copy of the key loaded from path. It reports ErrNoSuchKey if
+// no key from that path is held by a.
+func (a *Agent) Get(path string) ([]byte, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ak, ok := a.keys[path]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrNoSuchKey, path)
+	}
+	return append([]byte(nil), ak.key...), nil
+}
+
+// Paths returns the paths of the keys held by a, in no particular order.
+func (a *Agent) Paths() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	paths := make([]string, 0, len(a.keys))
+	for path := range a.keys {
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// Forget clears and discards the key loaded from path, if any, and reports
+// whether a key was held.
+func (a *Agent) Forget(path string) bool {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.forgetLocked(path)
+}
+
+// Close clears and discards all the keys held by a. It always returns nil.
+// The agent remains usable after Close, but holds no keys.
+func (a *Agent) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for path := range a.keys {
+		a.forgetLocked(path)
+	}
+	return nil
+}
+
+func (a *Agent) forgetLocked(path string) bool {
+	ak, ok := a.keys[path]
+	if ok {
+		if ak.timer != nil {
+			ak.timer.Stop()
+		}
+		clear(ak.key)
+		delete(a.keys, path)
+	}
+	return ok
+}
+
+// Serve accepts connections from lst and replies to each request for a key,
+// until lst is closed. It returns nil if lst was closed, otherwise the error
+// from accepting a connection. Serve does not close lst.
+func (a *Agent) Serve(lst net.Listener) error {
+	for {
+		conn, err := lst.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		go a.handle(conn)
+	}
+}
+
+// handle replies to a single client request on conn, then closes it.
+func (a *Agent) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(agentTimeout))
+	path, err := bufio.NewReader(io.LimitReader(conn, 4096)).ReadString('\n')
+	if err != nil {
+		conn.Write(append([]byte{agentError}, "invalid request"...))
+		return
+	}
+	key, err := a.Get(strings.TrimSuffix(path, "\n"))
+	if errors.Is(err, ErrNoSuchKey) {
+		conn.Write([]byte{agentNoKey})
+		return
+	} else if err != nil {
+		conn.Write(append([]byte{agentError}, err.Error()...))
+		return
+	}
+	defer clear(key)
+	if _, err := conn.Write([]byte{agentOK}); err == nil {
+		conn.Write(key)
+	}
+}
+
+// AgentKey requests the key loaded from path by the agent listening on the
+// Unix-domain socket at socketPath. It reports ErrNoSuchKey if the agent does
+// not hold that key.
+func AgentKey(ctx context.Context, socketPath, path string) ([]byte, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	} else if strings.Contains(path, "\n") {
+		return nil, fmt.Errorf("invalid path %q", path)
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("agent: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(agentTimeout))
+	if _, err := io.WriteString(conn, path+"\n"); err != nil {
+		return nil, fmt.Errorf("agent: %w", err)
+	}
+	reply, err := io.ReadAll(io.LimitReader(conn, DefaultMaxFileSize))
+	if err != nil {
+		return nil, fmt.Errorf("agent: %w", err)
+	} else if len(reply) == 0 {
+		return nil, errors.New("agent: empty reply")
+	}
+	switch reply[0] {
+	case agentOK:
+		return reply[1:], nil
+	case agentNoKey:
+		return nil, fmt.Errorf("agent: %w: %q", ErrNoSuchKey, path)
+	default:
+		return nil, fmt.Errorf("agent: %s", reply[1:])
+	}
+}
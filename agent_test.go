@@ -0,0 +1,114 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package keyfile_test
+
+import (
+	"context"
+	crand "crypto/rand"
+	"errors"
+	"io"
+	mrand "math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/creachadair/keyfile"
+	"github.com/creachadair/mds/mtest"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAgent(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20261016131502)))
+	const passphrase = "once per session"
+	dir := t.TempDir()
+
+	f := keyfile.New(keyfile.WithScryptN(1 << 10))
+	want, err := f.Random(passphrase, 32)
+	if err != nil {
+		t.Fatalf("Random: unexpected error: %v", err)
+	}
+	keyPath := filepath.Join(dir, "test.key")
+	if err := os.WriteFile(keyPath, f.Encode(), 0600); err != nil {
+		t.Fatalf("Write keyfile: %v", err)
+	}
+	otherPath := filepath.Join(dir, "other.key")
+	if err := os.WriteFile(otherPath, f.Encode(), 0600); err != nil {
+		t.Fatalf("Write keyfile: %v", err)
+	}
+
+	a := keyfile.NewAgent(0)
+	defer a.Close()
+	if err := a.Load(keyPath, func() (string, error) { return "wrong", nil }); !errors.Is(err, keyfile.ErrBadPassphrase) {
+		t.Errorf("Load with wrong passphrase: got %v, want %v", err, keyfile.ErrBadPassphrase)
+	}
+	if err := a.Load(keyPath, func() (string, error) { return passphrase, nil }); err != nil {
+		t.Fatalf("Load: unexpected error: %v", err)
+	}
+
+	sock := filepath.Join(dir, "agent.sock")
+	lst, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- a.Serve(lst) }()
+
+	ctx := context.Background()
+	if got, err := keyfile.AgentKey(ctx, sock, keyPath); err != nil {
+		t.Errorf("AgentKey: unexpected error: %v", err)
+	} else if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("AgentKey (-want, +got):\n%s", diff)
+	}
+
+	// A key that was not loaded is not served, even if the file exists.
+	if got, err := keyfile.AgentKey(ctx, sock, otherPath); !errors.Is(err, keyfile.ErrNoSuchKey) {
+		t.Errorf("AgentKey(other): got %q, %v; want %v", got, err, keyfile.ErrNoSuchKey)
+	}
+
+	// A forgotten key is no longer served.
+	if !a.Forget(keyPath) {
+		t.Error("Forget: got false, want true")
+	}
+	if got, err := keyfile.AgentKey(ctx, sock, keyPath); !errors.Is(err, keyfile.ErrNoSuchKey) {
+		t.Errorf("AgentKey after Forget: got %q, %v; want %v", got, err, keyfile.ErrNoSuchKey)
+	}
+
+	lst.Close()
+	if err := <-done; err != nil {
+		t.Errorf("Serve: unexpected error: %v", err)
+	}
+}
+
+func TestAgentTTL(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20261016132040)))
+	const passphrase = "fleeting"
+
+	f := keyfile.New(keyfile.WithScryptN(1 << 10))
+	if _, err := f.Random(passphrase, 16); err != nil {
+		t.Fatalf("Random: unexpected error: %v", err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "test.key")
+	if err := os.WriteFile(keyPath, f.Encode(), 0600); err != nil {
+		t.Fatalf("Write keyfile: %v", err)
+	}
+
+	a := keyfile.NewAgent(50 * time.Millisecond)
+	defer a.Close()
+	if err := a.Load(keyPath, func() (string, error) { return passphrase, nil }); err != nil {
+		t.Fatalf("Load: unexpected error: %v", err)
+	}
+	if _, err := a.Get(keyPath); err != nil {
+		t.Errorf("Get: unexpected error: %v", err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); len(a.Paths()) != 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("Key was not forgotten after its TTL expired")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got, err := a.Get(keyPath); !errors.Is(err, keyfile.ErrNoSuchKey) {
+		t.Errorf("Get after TTL: got %q, %v; want %v", got, err, keyfile.ErrNoSuchKey)
+	}
+}
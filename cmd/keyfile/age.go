@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+
+	"filippo.io/age"
+)
+
+// ageWrapper implements keyfile.Wrapper by encrypting to age recipients.
+type ageWrapper struct{ recipients []age.Recipient }
+
+func (w ageWrapper) Wrap(secret []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	aw, err := age.Encrypt(&buf, w.recipients...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := aw.Write(secret); err != nil {
+		return nil, err
+	} else if err := aw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ageUnwrapper implements keyfile.Unwrapper by decrypting with age identities.
+type ageUnwrapper struct{ identities []age.Identity }
+
+func (u ageUnwrapper) Unwrap(data []byte) ([]byte, error) {
+	r, err := age.Decrypt(bytes.NewReader(data), u.identities...)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func parseAgeRecipients(spec string) ([]age.Recipient, error) {
+	if spec == "" {
+		return nil, errors.New("missing --recipient")
+	}
+	return age.ParseRecipients(bytes.NewReader([]byte(spec)))
+}
+
+func loadAgeIdentities(path string) ([]age.Identity, error) {
+	if path == "" {
+		return nil, errors.New("missing --identity file")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return age.ParseIdentities(f)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// A clipboard copies data to and from the system clipboard using external
+// programs.
+type clipboard struct {
+	copy, paste []string // command lines to write and read the clipboard
+}
+
+// clipboards are the supported clipboard programs, in order of preference.
+// The env field, if set, names an environment variable that must be set for
+// the program to be usable.
+var clipboards = []struct {
+	goos, env string
+	clipboard
+}{
+	{"darwin", "", clipboard{[]string{"pbcopy"}, []string{"pbpaste"}}},
+	{"", "WAYLAND_DISPLAY", clipboard{[]string{"wl-copy"}, []string{"wl-paste", "--no-newline"}}},
+	{"", "DISPLAY", clipboard{
+		[]string{"xclip", "-selection", "clipboard"},
+		[]string{"xclip", "-selection", "clipboard", "-o"},
+	}},
+	{"", "DISPLAY", clipboard{
+		[]string{"xsel", "--clipboard", "--input"},
+		[]string{"xsel", "--clipboard", "--output"},
+	}},
+}
+
+// findClipboard returns the first usable clipboard, or an error if there is
+// none, for example on a headless system.
+func findClipboard() (*clipboard, error) {
+	for _, c := range clipboards {
+		if c.goos != "" && c.goos != runtime.GOOS {
+			continue
+		} else if c.env != "" && os.Getenv(c.env) == "" {
+			continue
+		} else if _, err := exec.LookPath(c.copy[0]); err != nil {
+			continue
+		}
+		return &c.clipboard, nil
+	}
+	return nil, errors.New("no clipboard is available (requires pbcopy, wl-copy, xclip, or xsel and a display)")
+}
+
+// Write replaces the contents of the clipboard with data.
+func (c *clipboard) Write(data []byte) error {
+	cmd := exec.Command(c.copy[0], c.copy[1:]...)
+	cmd.Stdin = bytes.NewReader(data)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("write clipboard: %w", err)
+	}
+	return nil
+}
+
+// Read returns the current contents of the clipboard.
+func (c *clipboard) Read() ([]byte, error) {
+	out, err := exec.Command(c.paste[0], c.paste[1:]...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("read clipboard: %w", err)
+	}
+	return out, nil
+}
+
+// clipKey copies data to the clipboard. If timeout > 0, it then waits until
+// timeout elapses or ctx ends, and clears the clipboard if it still contains
+// data.
+func clipKey(ctx context.Context, c *clipboard, data []byte, timeout time.Duration) error {
+	if err := c.Write(data); err != nil {
+		return err
+	} else if timeout <= 0 {
+		fmt.Fprintln(os.Stderr, "Copied key to the clipboard")
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "Copied key to the clipboard; it will be cleared in %v\n", timeout)
+	select {
+	case <-ctx.Done():
+	case <-time.After(timeout):
+	}
+
+	// Clear the clipboard only if nothing else has replaced its contents.
+	cur, err := c.Read()
+	if err != nil {
+		return err
+	}
+	defer clear(cur)
+	if !bytes.Equal(cur, data) {
+		return nil
+	}
+	return c.Write(nil)
+}
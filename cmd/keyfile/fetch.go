@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/creachadair/keyfile"
+)
+
+// A fetchFunc fetches the encoded packet of a key file named by a URL.
+// Only the encrypted packet is fetched; decryption always happens locally.
+type fetchFunc func(ctx context.Context, u *url.URL) ([]byte, error)
+
+// fetchers maps URL schemes to the functions that fetch them.  Builds that
+// want to support additional schemes can add to this map in an init function.
+var fetchers = map[string]fetchFunc{
+	"file": fetchFile,
+}
+
+func fetchFile(_ context.Context, u *url.URL) ([]byte, error) {
+	if u.Host != "" && u.Host != "localhost" {
+		return nil, fmt.Errorf("file URL has non-local host %q", u.Host)
+	} else if u.Path == "" {
+		return nil, errors.New("file URL has no path")
+	}
+	fi, err := os.Stat(u.Path)
+	if err != nil {
+		return nil, err
+	} else if !fi.Mode().IsRegular() {
+		return nil, fmt.Errorf("%w: %q", keyfile.ErrNotRegularFile, u.Path)
+	} else if fi.Size() > keyfile.DefaultMaxFileSize {
+		return nil, fmt.Errorf("%w: %d bytes", keyfile.ErrFileTooLarge, fi.Size())
+	}
+	f, err := os.Open(u.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// The file may have grown since we checked its size.
+	data, err := io.ReadAll(io.LimitReader(f, keyfile.DefaultMaxFileSize+1))
+	if err != nil {
+		return nil, err
+	} else if len(data) > keyfile.DefaultMaxFileSize {
+		return nil, fmt.Errorf("%w: %q exceeds %d bytes", keyfile.ErrFileTooLarge, u.Path, keyfile.DefaultMaxFileSize)
+	}
+	return data, nil
+}
+
+func isURL(s string) bool { return strings.Contains(s, "://") }
+
+func fetchKeyFile(ctx context.Context, tag, spec string) ([]byte, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	fetch, ok := fetchers[u.Scheme]
+	if !ok {
+		var known []string
+		for s := range fetchers {
+			known = append(known, s)
+		}
+		sort.Strings(known)
+		return nil, fmt.Errorf("unsupported URL scheme %q (supported: %s)", u.Scheme, strings.Join(known, ", "))
+	}
+	data, err := fetch(ctx, u)
+	if err != nil {
+		return nil, fmt.Errorf("fetch %s: %w", spec, err)
+	}
+	kf, err := keyfile.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("load: %w", err)
+	}
+	pp, err := getPassphrase(tag, false)
+	if err != nil {
+		return nil, err
+	}
+	stop := startProgress()
+	key, err := kf.Get(pp)
+	stop()
+	if err != nil {
+		return nil, fmt.Errorf("load: %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,41 @@
+//go:build keyfile_http
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/creachadair/keyfile"
+)
+
+// Building with the keyfile_http tag enables fetching key files over HTTP(S).
+func init() {
+	fetchers["http"] = fetchHTTP
+	fetchers["https"] = fetchHTTP
+}
+
+func fetchHTTP(ctx context.Context, u *url.URL) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP status %s", rsp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(rsp.Body, keyfile.DefaultMaxFileSize+1))
+	if err != nil {
+		return nil, err
+	} else if len(data) > keyfile.DefaultMaxFileSize {
+		return nil, fmt.Errorf("%w: response exceeds %d bytes", keyfile.ErrFileTooLarge, keyfile.DefaultMaxFileSize)
+	}
+	return data, nil
+}
@@ -3,6 +3,8 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
@@ -14,23 +16,81 @@ import (
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/creachadair/atomicfile"
 	"github.com/creachadair/command"
 	"github.com/creachadair/flax"
-	"github.com/creachadair/getpass"
 	"github.com/creachadair/keyfile"
 	"golang.org/x/crypto/hkdf"
 	"golang.org/x/sys/unix"
+	"golang.org/x/term"
 )
 
 var flags struct {
-	EmptyOK bool `flag:"empty-ok,If true, an empty passphrase is allowed (not recommended)"`
+	EmptyOK       bool   `flag:"empty-ok,If true, an empty passphrase is allowed (not recommended)"`
+	RequireStrong bool   `flag:"require-strong,If true, reject new passphrases that appear weak"`
+	Progress      string `flag:"progress,default=auto,Show progress during key derivation (auto, always, never)"`
+
+	PassphraseEnv  string `flag:"passphrase-env,Read the passphrase from this environment variable instead of prompting"`
+	PassphraseFile string `flag:"passphrase-file,Read the passphrase from this file instead of prompting"`
+
+	NewPassphraseEnv  string `flag:"new-passphrase-env,Read the new passphrase (rekey, split, combine) from this environment variable"`
+	NewPassphraseFile string `flag:"new-passphrase-file,Read the new passphrase (rekey, split, combine) from this file"`
 }
 
 var getFlags struct {
-	Raw bool `flag:"raw,Write key output as binary"`
+	Encoding string `flag:"encoding,default=base64,Output encoding (base64, base64url, hex, raw)"`
+	Raw      bool   `flag:"raw,Write key output as binary (deprecated: use --encoding=raw)"`
+	Agent    string `flag:"agent,Request the key from the agent listening on this socket"`
+
+	Clip        bool          `flag:"clip,Copy the key to the clipboard instead of printing it"`
+	ClipTimeout time.Duration `flag:"clip-timeout,default=30s,With --clip, clear the clipboard after this duration (0 means never)"`
+}
+
+var setFlags struct {
+	DryRun     bool `flag:"dry-run,Check the key and passphrase, but do not write the key file"`
+	CreateOnly bool `flag:"create-only,Fail if the key file already exists, rather than replacing it"`
+}
+
+var writeFlags struct {
+	Force bool `flag:"force,Overwrite an existing key file without asking"`
+}
+
+var rekeyFlags struct {
+	DryRun    bool `flag:"dry-run,Check the old and new passphrases, but do not write the key file"`
+	SaltBytes int  `flag:"salt-bytes,Length in bytes of the new key generation salt (default: keep the current length, at least 16)"`
+}
+
+var migrateFlags struct {
+	DryRun bool `flag:"dry-run,Report what would change, but do not prompt or write the key file"`
+}
+
+var wrapFlags struct {
+	Remove bool `flag:"remove,Remove the plaintext file after wrapping it"`
+}
+
+var exportAgeFlags struct {
+	Recipient string `flag:"recipient,The age public key to encrypt for (age1...)"`
+}
+
+var importAgeFlags struct {
+	Identity string `flag:"identity,Path of an age identity file to decrypt with"`
+}
+
+var splitFlags struct {
+	Shares    int `flag:"shares,default=5,Number of shares to generate"`
+	Threshold int `flag:"threshold,default=3,Number of shares needed to recover the key"`
+}
+
+var agentFlags struct {
+	TTL time.Duration `flag:"ttl,Forget the keys after this duration (0 means never)"`
+}
+
+var combineFlags struct {
+	Out string `flag:"out,Write the recovered key to this key file (required)"`
 }
 
 func main() {
@@ -44,86 +104,281 @@ Keys can be specified in various formats:
 - The prefix "#x" indicates a string of hexadecimal digits (#x12ab).
 - The prefix "@" indcates a base64 string (@Eqs=).
 - The string "-" instructs the program to read the key from stdin.
-- Otherwise a key argument is taken verbatim.`,
+- Otherwise a key argument is taken verbatim.
+
+Passphrases are read from the terminal, unless --passphrase-env or
+--passphrase-file is set. In that case no confirmation is requested.
+Commands that also set a new passphrase (rekey, split, and combine) read
+it from --new-passphrase-env or --new-passphrase-file, and require one of
+these when the first passphrase is given by a flag. For combine, the flag
+passphrase is used for every share.`,
 		SetFlags: command.Flags(flax.MustBind, &flags),
 
 		Commands: []*command.C{
 			{
-				Name:     "get",
-				Usage:    "<key-file>",
-				Help:     "Print the contents of the key file to stdout.",
+				Name:  "get",
+				Usage: "<key-file>",
+				Help: `Print the contents of the key file to stdout.
+
+The key file may also be given as a URL, such as file:///path/to/key.
+The encrypted key file is fetched from the URL and decrypted locally.
+
+With --agent, the decrypted key is requested from a running agent (see
+"agent") instead, without prompting for a passphrase.
+
+With --clip, the key is copied to the system clipboard in the selected
+encoding instead of being printed, and the command waits until the clip
+timeout elapses (or it is interrupted) to clear the clipboard, unless
+something else has replaced its contents. It is an error if no clipboard is
+available.`,
 				SetFlags: command.Flags(flax.MustBind, &getFlags),
+				Run: command.Adapt(func(env *command.Env, keyFile string) error {
+					enc := getFlags.Encoding
+					if getFlags.Raw {
+						enc = "raw"
+					}
+					encode, ok := encodings[enc]
+					if !ok {
+						return fmt.Errorf("unknown encoding %q", enc)
+					}
+					var clip *clipboard
+					if getFlags.Clip {
+						// Check before decrypting, so that the key is not
+						// obtained if it cannot be delivered.
+						var err error
+						clip, err = findClipboard()
+						if err != nil {
+							return err
+						}
+					}
+
+					var key []byte
+					var err error
+					if getFlags.Agent != "" {
+						key, err = keyfile.AgentKey(env.Context(), getFlags.Agent, keyFile)
+					} else if isURL(keyFile) {
+						key, err = fetchKeyFile(env.Context(), "", keyFile)
+					} else {
+						key, err = loadKeyFile("", keyFile)
+					}
+					if err != nil {
+						return err
+					}
+					out := encode(key)
+					if clip != nil {
+						if enc != "raw" {
+							out = bytes.TrimSuffix(out, []byte("\n"))
+						}
+						ctx, cancel := signal.NotifyContext(env.Context(), syscall.SIGINT, syscall.SIGTERM)
+						defer cancel()
+						return clipKey(ctx, clip, out, getFlags.ClipTimeout)
+					}
+					_, err = os.Stdout.Write(out)
+					return err
+				}),
+			}, {
+				Name:  "len",
+				Usage: "<key-file>",
+				Help:  "Print the length in bytes of the key in the key file, but not the key.",
 				Run: command.Adapt(func(env *command.Env, keyFile string) error {
 					key, err := loadKeyFile("", keyFile)
 					if err != nil {
 						return err
 					}
-					if getFlags.Raw {
-						os.Stdout.Write(key)
-					} else {
-						fmt.Println(base64.StdEncoding.EncodeToString(key))
+					fmt.Println(len(key))
+					return nil
+				}),
+			}, {
+				Name:  "params",
+				Usage: "<key-file>",
+				Help: `Print the key derivation parameters of the key file, without decrypting it.
+
+The salt and nonce are printed in hex, followed by the KDF and its cost
+settings, on a single line.`,
+				Run: command.Adapt(func(env *command.Env, keyFile string) error {
+					kf, err := openKeyFile(keyFile)
+					if err != nil {
+						return err
+					} else if kf.IsEmpty() {
+						return keyfile.ErrNoKey
+					}
+					fmt.Printf("salt=%x nonce=%x cipher=%v kdf=%v", kf.Salt(), kf.Nonce(), kf.Cipher(), kf.KDF())
+					switch kf.KDF() {
+					case keyfile.Scrypt:
+						n, r, p := kf.ScryptParams()
+						fmt.Printf(" N=%d r=%d p=%d\n", n, r, p)
+					case keyfile.Argon2id:
+						t, m, p := kf.Argon2Params()
+						fmt.Printf(" t=%d m=%d p=%d\n", t, m, p)
 					}
 					return nil
 				}),
 			}, {
-				Name:  "set",
-				Usage: "<key-file> <key>",
-				Help:  "Create or replace the contents of the key file with the given key.",
+				Name:     "set",
+				Usage:    "<key-file> <key>",
+				Help:     "Create or replace the contents of the key file with the given key.",
+				SetFlags: command.Flags(flax.MustBind, &setFlags, &writeFlags),
 				Run: command.Adapt(func(env *command.Env, keyFile, keySpec string) error {
+					if !setFlags.DryRun && !setFlags.CreateOnly {
+						if err := checkOverwrite(keyFile); err != nil {
+							return err
+						}
+					}
 					key, err := decodeKey(keySpec)
 					if err != nil {
 						return fmt.Errorf("decoding key: %w", err)
 					}
-					kf, err := setKey("", key)
+					if setFlags.CreateOnly && !setFlags.DryRun {
+						return createKeyFile(keyFile, key)
+					}
+					pp, err := getPassphrase("", true)
 					if err != nil {
 						return err
 					}
+					kf, err := setKey(pp, key)
+					if err != nil {
+						return err
+					} else if setFlags.DryRun {
+						reportDryRun(keyFile, kf, len(key))
+						if cur, err := openKeyFile(keyFile); err == nil && !cur.IsEmpty() {
+							fmt.Printf("  %q already holds a key, which would be replaced\n", keyFile)
+						}
+						return nil
+					}
 					return saveKeyFile(keyFile, kf)
 				}),
 			}, {
 				Name:  "rekey",
 				Usage: "<key-file>",
-				Help:  "Change the passphrase on an existing key file.",
+				Help: `Change the passphrase on an existing key file.
+
+The KDF, cipher, cost settings, and comment of the key file are kept. The
+salt length is kept too, but not less than the default, unless --salt-bytes
+is set.`,
+				SetFlags: command.Flags(flax.MustBind, &rekeyFlags),
 				Run: command.Adapt(func(env *command.Env, keyFile string) error {
-					key, err := loadKeyFile("Old ", keyFile)
+					n := rekeyFlags.SaltBytes
+					if n != 0 && (n < keyfile.MinSaltBytes || n > keyfile.MaxSaltBytes) {
+						return fmt.Errorf("salt size must be %d to %d bytes", keyfile.MinSaltBytes, keyfile.MaxSaltBytes)
+					}
+					kf, err := openKeyFile(keyFile)
+					if err != nil {
+						return err
+					}
+					if n == 0 {
+						n = min(max(kf.Header().SaltLen, keyfile.DefaultSaltBytes), keyfile.MaxSaltBytes)
+					}
+					key, err := decryptKey("Old ", kf)
 					if err != nil {
 						return err
 					}
-					kf, err := setKey("New ", key)
+					defer clear(key)
+					pp, err := getNewPassphrase("New ")
 					if err != nil {
 						return err
 					}
+					old := kf.Header()
+					if err := sealKey(kf, pp, key, n); err != nil {
+						return err
+					} else if rekeyFlags.DryRun {
+						reportDryRun(keyFile, kf, len(key))
+						fmt.Printf("  old: %s\n  new: %s\n", formatHeader(old), formatHeader(kf.Header()))
+						return nil
+					}
 					return saveKeyFile(keyFile, kf)
 				}),
 			}, {
-				Name:  "random",
-				Usage: "<key-file> <n>",
-				Help:  "Write a randomly-generated key of n bytes to the key file.",
+				Name:  "migrate",
+				Usage: "<key-file>",
+				Help: `Upgrade the key file to the current format and work factor.
+
+The key is re-encrypted with the same passphrase, KDF, cipher, and comment,
+using the current packet format and a salt at least as long as the default.
+Cost settings weaker than the defaults for the KDF are raised to the defaults;
+stronger settings are kept. If the file is already current, it is left
+unchanged.`,
+				SetFlags: command.Flags(flax.MustBind, &migrateFlags),
+				Run: command.Adapt(func(env *command.Env, keyFile string) error {
+					kf, err := openKeyFile(keyFile)
+					if err != nil {
+						return err
+					} else if kf.IsEmpty() {
+						return keyfile.ErrNoKey
+					}
+					old := kf.Header()
+					want, def := old, keyfile.New(keyfile.WithKDF(old.KDF)).Header()
+					want.Version = def.Version
+					if old.Weak {
+						want.Params, want.Weak = def.Params, false // never lower the cost
+					}
+					want.SaltLen = max(old.SaltLen, keyfile.DefaultSaltBytes)
+					changes := headerChanges(old, want)
+					if len(changes) == 0 {
+						fmt.Printf("%s is already current\n", keyFile)
+						return nil
+					} else if migrateFlags.DryRun {
+						fmt.Printf("dry run: would migrate %q: %s\n", keyFile, strings.Join(changes, ", "))
+						return nil
+					}
+
+					pp, err := getPassphrase("", false)
+					if err != nil {
+						return err
+					}
+					stop := startProgress()
+					key, err := kf.Get(pp)
+					if err == nil {
+						err = kf.SetSaltSize(pp, key, want.SaltLen)
+					}
+					clear(key)
+					stop()
+					if err != nil {
+						return fmt.Errorf("migrate: %w", err)
+					} else if err := saveKeyFile(keyFile, kf); err != nil {
+						return err
+					}
+					fmt.Printf("migrated %q: %s\n", keyFile, strings.Join(changes, ", "))
+					return nil
+				}),
+			}, {
+				Name:     "random",
+				Usage:    "<key-file> <n>",
+				Help:     "Write a randomly-generated key of n bytes to the key file.",
+				SetFlags: command.Flags(flax.MustBind, &writeFlags),
 				Run: command.Adapt(func(env *command.Env, keyFile, size string) error {
 					n, err := checkSize(size)
 					if err != nil {
 						return err
+					} else if err := checkOverwrite(keyFile); err != nil {
+						return err
 					}
 
 					kf := keyfile.New()
 					pp, err := getPassphrase("", true)
 					if err != nil {
 						return err
-					} else if _, err := kf.Random(pp, n); err != nil {
+					}
+					stop := startProgress()
+					_, err = kf.Random(pp, n)
+					stop()
+					if err != nil {
 						return fmt.Errorf("generate random key: %w", err)
 					}
 					return saveKeyFile(keyFile, kf)
 				}),
 			}, {
-				Name:  "hkdf",
-				Usage: "<key-file> <salt> <n>",
-				Help:  "Write an HKDF (SHA256) key of n bytes to the key file.",
+				Name:     "hkdf",
+				Usage:    "<key-file> <salt> <n>",
+				Help:     "Write an HKDF (SHA256) key of n bytes to the key file.",
+				SetFlags: command.Flags(flax.MustBind, &writeFlags),
 				Run: command.Adapt(func(env *command.Env, keyFile, salt, size string) error {
 					n, err := checkSize(size)
 					if err != nil {
 						return err
 					} else if salt == "" {
 						return errors.New("empty key generation salt")
+					} else if err := checkOverwrite(keyFile); err != nil {
+						return err
 					}
 
 					kf := keyfile.New()
@@ -135,28 +390,367 @@ Keys can be specified in various formats:
 					buf := make([]byte, n)
 					if _, err := io.ReadFull(h, buf); err != nil {
 						return err
-					} else if err := kf.Set(pp, buf); err != nil {
+					}
+					stop := startProgress()
+					err = kf.Set(pp, buf)
+					stop()
+					if err != nil {
 						return err
 					}
 					return saveKeyFile(keyFile, kf)
 				}),
+			}, {
+				Name:  "wrap",
+				Usage: "<plain-file> <key-file>",
+				Help: `Seal the contents of a plaintext file into a key file.
+
+The raw contents of the plain file are used as the key. With --remove,
+the plain file is deleted once the key file has been written.`,
+				SetFlags: command.Flags(flax.MustBind, &wrapFlags, &writeFlags),
+				Run: command.Adapt(func(env *command.Env, plainFile, keyFile string) error {
+					if err := checkOverwrite(keyFile); err != nil {
+						return err
+					}
+					key, err := os.ReadFile(plainFile)
+					if err != nil {
+						return err
+					} else if len(key) == 0 {
+						return fmt.Errorf("plain file %q is empty", plainFile)
+					}
+					pp, err := getPassphrase("", true)
+					if err != nil {
+						return err
+					}
+					kf, err := setKey(pp, key)
+					if err != nil {
+						return err
+					} else if err := saveKeyFile(keyFile, kf); err != nil {
+						return err
+					}
+					if wrapFlags.Remove {
+						return os.Remove(plainFile)
+					}
+					return nil
+				}),
+			}, {
+				Name:  "unwrap",
+				Usage: "<key-file> <plain-file>",
+				Help: `Write the key from a key file to a plaintext file.
+
+The plain file is written with mode 0600 and contains the raw key.
+This is the reverse of the wrap command. If the plain file is an existing
+key file, it is replaced only with confirmation or --force.`,
+				SetFlags: command.Flags(flax.MustBind, &writeFlags),
+				Run: command.Adapt(func(env *command.Env, keyFile, plainFile string) error {
+					if err := checkOverwrite(plainFile); err != nil {
+						return err
+					}
+					key, err := loadKeyFile("", keyFile)
+					if err != nil {
+						return err
+					}
+					return atomicfile.Tx(plainFile, 0600, func(f *atomicfile.File) error {
+						_, err := f.Write(key)
+						return err
+					})
+				}),
+			}, {
+				Name:  "encrypt",
+				Usage: "<key-file>",
+				Help: `Encrypt stdin with the key in the key file, and write it to stdout.
+
+The key file must contain a 32-byte key, which is used with AES-256-GCM.
+The output is a random nonce followed by the encrypted data.`,
+				Run: command.Adapt(func(env *command.Env, keyFile string) error {
+					return cryptStream(keyFile, (*keyfile.File).Encrypt)
+				}),
+			}, {
+				Name:  "decrypt",
+				Usage: "<key-file>",
+				Help: `Decrypt stdin with the key in the key file, and write it to stdout.
+
+The input must have been produced by the encrypt command with the same key.`,
+				Run: command.Adapt(func(env *command.Env, keyFile string) error {
+					return cryptStream(keyFile, (*keyfile.File).Decrypt)
+				}),
+			}, {
+				Name:  "export-age",
+				Usage: "<key-file>",
+				Help: `Export the key from a key file as an age-encrypted file.
+
+The key is decrypted and re-encrypted to the age recipient given by
+--recipient. The age file is written to stdout.`,
+				SetFlags: command.Flags(flax.MustBind, &exportAgeFlags),
+				Run: command.Adapt(func(env *command.Env, keyFile string) error {
+					rs, err := parseAgeRecipients(exportAgeFlags.Recipient)
+					if err != nil {
+						return fmt.Errorf("age recipient: %w", err)
+					}
+					kf, err := openKeyFile(keyFile)
+					if err != nil {
+						return err
+					}
+					pp, err := getPassphrase("", false)
+					if err != nil {
+						return err
+					}
+					stop := startProgress()
+					data, err := kf.Export(pp, ageWrapper{recipients: rs})
+					stop()
+					if err != nil {
+						return err
+					}
+					_, err = os.Stdout.Write(data)
+					return err
+				}),
+			}, {
+				Name:  "import-age",
+				Usage: "<age-file> <key-file>",
+				Help: `Import a key from an age-encrypted file into a key file.
+
+The age file is decrypted with the identities in the file given by
+--identity, and the contents are stored as the key in the key file.`,
+				SetFlags: command.Flags(flax.MustBind, &importAgeFlags, &writeFlags),
+				Run: command.Adapt(func(env *command.Env, ageFile, keyFile string) error {
+					ids, err := loadAgeIdentities(importAgeFlags.Identity)
+					if err != nil {
+						return fmt.Errorf("age identity: %w", err)
+					} else if err := checkOverwrite(keyFile); err != nil {
+						return err
+					}
+					data, err := os.ReadFile(ageFile)
+					if err != nil {
+						return err
+					}
+					pp, err := getPassphrase("", true)
+					if err != nil {
+						return err
+					}
+					kf := keyfile.New()
+					stop := startProgress()
+					err = kf.Import(pp, data, ageUnwrapper{identities: ids})
+					stop()
+					if err != nil {
+						return err
+					}
+					return saveKeyFile(keyFile, kf)
+				}),
+			}, {
+				Name:  "diff",
+				Usage: "<old-key-file> <new-key-file>",
+				Help: `Report how two key files differ.
+
+Both key files must decrypt with the same passphrase. Diff reports whether
+the keys differ, identifying each by a short fingerprint, and which of the
+file parameters (salt, nonce, KDF and cost, cipher) changed. It never
+prints either key.`,
+				Run: command.Adapt(func(env *command.Env, oldFile, newFile string) error {
+					a, err := openKeyFile(oldFile)
+					if err != nil {
+						return err
+					}
+					b, err := openKeyFile(newFile)
+					if err != nil {
+						return err
+					}
+					pp, err := getPassphrase("", false)
+					if err != nil {
+						return err
+					}
+					stop := startProgress()
+					r, err := keyfile.Diff(a, b, pp)
+					stop()
+					if err != nil {
+						return err
+					}
+					if r.SecretChanged {
+						fmt.Printf("key:   changed (%s → %s)\n", r.OldFingerprint, r.NewFingerprint)
+					} else {
+						fmt.Printf("key:   unchanged (%s)\n", r.OldFingerprint)
+					}
+					if r.OldLen != r.NewLen {
+						fmt.Printf("size:  changed (%d → %d bytes)\n", r.OldLen, r.NewLen)
+					}
+					fmt.Printf("salt:  %s\n", changed(r.SaltChanged, "rotated"))
+					fmt.Printf("nonce: %s\n", changed(r.NonceChanged, "rotated"))
+					oldKDF := fmt.Sprintf("%s %s", r.OldKDF, formatParams(keyfile.Header{KDF: r.OldKDF, Params: r.OldParams}))
+					newKDF := fmt.Sprintf("%s %s", r.NewKDF, formatParams(keyfile.Header{KDF: r.NewKDF, Params: r.NewParams}))
+					if oldKDF != newKDF {
+						fmt.Printf("kdf:   changed (%s → %s)\n", oldKDF, newKDF)
+					} else {
+						fmt.Printf("kdf:   unchanged (%s)\n", oldKDF)
+					}
+					if r.OldCipher != r.NewCipher {
+						fmt.Printf("cipher: changed (%s → %s)\n", r.OldCipher, r.NewCipher)
+					} else {
+						fmt.Printf("cipher: unchanged (%s)\n", r.OldCipher)
+					}
+					return nil
+				}),
+			}, {
+				Name:  "split",
+				Usage: "<key-file>",
+				Help: `Split the key in a key file into shares.
+
+Split decrypts the key file and splits the key into the number of shares
+given by --shares, any --threshold of which are sufficient to recover it
+with the combine command.
+
+Each share is written as a separate key file named <key-file>.share-N,
+protected by a share passphrase. Since shares are ordinary key files, the
+holder of a share can change its passphrase with the rekey command.
+Existing share files are replaced only with confirmation or --force.`,
+				SetFlags: command.Flags(flax.MustBind, &splitFlags, &writeFlags),
+				Run: command.Adapt(func(env *command.Env, keyFile string) error {
+					for i := range splitFlags.Shares {
+						if err := checkOverwrite(sharePath(keyFile, i)); err != nil {
+							return err
+						}
+					}
+					kf, err := openKeyFile(keyFile)
+					if err != nil {
+						return err
+					}
+					pp, err := getPassphrase("", false)
+					if err != nil {
+						return err
+					}
+					stop := startProgress()
+					shares, err := kf.Split(pp, splitFlags.Shares, splitFlags.Threshold)
+					stop()
+					if err != nil {
+						return fmt.Errorf("split key: %w", err)
+					}
+					spp, err := getNewPassphrase("Share ")
+					if err != nil {
+						return err
+					}
+					for i, share := range shares {
+						sf := keyfile.New()
+						stop := startProgress()
+						err := sf.Set(spp, share)
+						stop()
+						if err != nil {
+							return err
+						}
+						path := sharePath(keyFile, i)
+						if err := saveKeyFile(path, sf); err != nil {
+							return err
+						}
+						fmt.Println(path)
+					}
+					return nil
+				}),
+			}, {
+				Name:  "combine",
+				Usage: "<share-file> ...",
+				Help: `Recover a key from shares and write it to a new key file.
+
+Combine decrypts each of the given share files, prompting for the passphrase
+of each in turn, and reconstructs the key from them. The recovered key is
+written to the key file given by --out, under a new passphrase. At least as
+many shares as the threshold used to split the key must be given. An
+existing --out file is replaced only with confirmation or --force.`,
+				SetFlags: command.Flags(flax.MustBind, &combineFlags, &writeFlags),
+				Run: command.Adapt(func(env *command.Env, shareFiles ...string) error {
+					if combineFlags.Out == "" {
+						return errors.New("missing --out key file")
+					} else if len(shareFiles) < 2 {
+						return errors.New("at least two share files are required")
+					} else if err := checkOverwrite(combineFlags.Out); err != nil {
+						return err
+					}
+					var shares [][]byte
+					for i, path := range shareFiles {
+						share, err := loadKeyFile(fmt.Sprintf("Share %d ", i+1), path)
+						if err != nil {
+							return err
+						}
+						shares = append(shares, share)
+					}
+					key, err := keyfile.Combine(shares)
+					if err != nil {
+						return fmt.Errorf("combine shares: %w", err)
+					}
+					pp, err := getNewPassphrase("New ")
+					if err != nil {
+						return err
+					}
+					kf, err := setKey(pp, key)
+					if err != nil {
+						return err
+					}
+					return saveKeyFile(combineFlags.Out, kf)
+				}),
 			}, {
 				Name:  "offer",
-				Usage: "<key-file> <socket-path>",
-				Help: `Write the contents of a key file to a named pipe.
+				Usage: "<key-file> <target>",
+				Help: `Write the contents of a key file to a named pipe or socket.
+
+After reading the key file, offer opens the target and waits for a single
+reader. When a reader connects, it writes the key, then closes the target.
 
-After reading the key file, offer opens a named pipe at the given path,
-creating it if necessary. When the pipe is opened by a reader, it writes
-the key, then closes (and, if created, removes) the pipe.`,
+If the target has the form unix://path, offer listens on a Unix-domain socket
+at that path, created with mode 0600 and removed when done.
 
-				Run: command.Adapt(func(env *command.Env, keyFile, pipeFile string) error {
+If the target has the form tcp://host:port, offer listens for a TCP connection
+on that address. The key is sent in plaintext, so use this only on a trusted
+network.
+
+Otherwise, the target is the path of a named pipe, which is created if
+necessary (and, if created, removed when done).`,
+
+				Run: command.Adapt(func(env *command.Env, keyFile, target string) error {
 					key, err := loadKeyFile("", keyFile)
 					if err != nil {
 						return err
 					}
 					ctx, cancel := signal.NotifyContext(env.Context(), syscall.SIGINT, syscall.SIGTERM)
 					defer cancel()
-					return offerKey(env.SetContext(ctx), pipeFile, key)
+					if network, addr, ok := socketTarget(target); ok {
+						return offerSocket(ctx, network, addr, key)
+					}
+					return offerKey(env.SetContext(ctx), target, key)
+				}),
+			},
+			{
+				Name:  "agent",
+				Usage: "<socket-path> <key-file> ...",
+				Help: `Hold decrypted keys in memory and serve them on a socket.
+
+The agent prompts once for a passphrase, which is used to decrypt each of
+the key files. It then listens on a Unix-domain socket at socket-path,
+created with mode 0600, and serves each key to clients that request it by
+the path of its key file (see "get --agent"). Only the key files named on
+the command line are served.
+
+With --ttl, the keys are cleared from memory once the duration has elapsed.
+The keys are also cleared when the agent is interrupted.`,
+				SetFlags: command.Flags(flax.MustBind, &agentFlags),
+				Run: command.Adapt(func(env *command.Env, socketPath string, keyFiles ...string) error {
+					if len(keyFiles) == 0 {
+						return env.Usagef("at least one key file is required")
+					}
+					a := keyfile.NewAgent(agentFlags.TTL)
+					defer a.Close()
+					pf := sync.OnceValues(func() (string, error) { return getPassphrase("", false) })
+					for _, path := range keyFiles {
+						stop := func() {}
+						err := a.Load(path, func() (string, error) {
+							pp, err := pf()
+							if err == nil {
+								stop = startProgress() // derivation follows immediately
+							}
+							return pp, err
+						})
+						stop()
+						if err != nil {
+							return fmt.Errorf("load %q: %w", path, err)
+						}
+					}
+					ctx, cancel := signal.NotifyContext(env.Context(), syscall.SIGINT, syscall.SIGTERM)
+					defer cancel()
+					return serveAgent(ctx, a, socketPath)
 				}),
 			},
 			command.HelpCommand(nil),
@@ -166,16 +760,35 @@ the key, then closes (and, if created, removes) the pipe.`,
 	command.RunOrFail(root.NewEnv(nil), os.Args[1:])
 }
 
-func setKey(tag string, key []byte) (*keyfile.File, error) {
+func setKey(pp string, key []byte) (*keyfile.File, error) {
 	kf := keyfile.New()
-	pp, err := getPassphrase(tag, true)
-	if err != nil {
+	if err := sealKey(kf, pp, key, keyfile.DefaultSaltBytes); err != nil {
 		return nil, err
 	}
-	if err := kf.Set(pp, key); err != nil {
+	return kf, nil
+}
+
+// sealKey stores key in kf under the passphrase pp, keeping the KDF, cipher,
+// and comment already selected for kf.
+func sealKey(kf *keyfile.File, pp string, key []byte, saltBytes int) error {
+	stop := startProgress()
+	defer stop()
+	return kf.SetSaltSize(pp, key, saltBytes)
+}
+
+// decryptKey prompts for a passphrase and decrypts the key stored in kf.
+func decryptKey(tag string, kf *keyfile.File) ([]byte, error) {
+	pp, err := getPassphrase(tag, false)
+	if err != nil {
 		return nil, err
 	}
-	return kf, nil
+	stop := startProgress()
+	defer stop()
+	key, err := kf.Get(pp)
+	if err != nil {
+		return nil, fmt.Errorf("load: %w", err)
+	}
+	return key, nil
 }
 
 func saveKeyFile(path string, kf *keyfile.File) error {
@@ -185,16 +798,142 @@ func saveKeyFile(path string, kf *keyfile.File) error {
 	})
 }
 
+func createKeyFile(path string, key []byte) error {
+	stop := func() {}
+	err := keyfile.SaveKeyIfAbsent(path, key, func() (string, error) {
+		pp, err := getPassphrase("", true)
+		if err == nil {
+			stop = startProgress()
+		}
+		return pp, err
+	})
+	stop()
+	if errors.Is(err, keyfile.ErrExists) {
+		return fmt.Errorf("key file %q already exists (--create-only)", path)
+	}
+	return err
+}
+
+func reportDryRun(path string, kf *keyfile.File, keyLen int) {
+	fmt.Printf("dry run: would write %d-byte key to %q (%d-byte packet)\n",
+		keyLen, path, len(kf.Encode()))
+}
+
+// cryptStream reads stdin, transforms it with the key stored in keyFile by
+// calling crypt, and writes the result to stdout.
+func cryptStream(keyFile string, crypt func(*keyfile.File, string, []byte) ([]byte, error)) error {
+	kf, err := openKeyFile(keyFile)
+	if err != nil {
+		return err
+	}
+	input, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("read input: %w", err)
+	}
+	pp, err := getPassphrase("", false)
+	if err != nil {
+		return err
+	}
+	stop := startProgress()
+	out, err := crypt(kf, pp, input)
+	stop()
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+// headerChanges describes the differences between the packet headers have and
+// want that are relevant to migration, or returns nil if there are none.
+func headerChanges(have, want keyfile.Header) []string {
+	var out []string
+	if have.Version != want.Version {
+		out = append(out, fmt.Sprintf("version %d -> %d", have.Version, want.Version))
+	}
+	if have.Params != want.Params {
+		out = append(out, fmt.Sprintf("%v %s -> %s", have.KDF, formatParams(have), formatParams(want)))
+	}
+	if have.SaltLen != want.SaltLen {
+		out = append(out, fmt.Sprintf("salt %d -> %d bytes", have.SaltLen, want.SaltLen))
+	}
+	return out
+}
+
+// formatHeader renders the KDF, cost parameters, cipher, and salt length of h
+// as text.
+func formatHeader(h keyfile.Header) string {
+	return fmt.Sprintf("%v %s, %v, %d-byte salt", h.KDF, formatParams(h), h.Cipher, h.SaltLen)
+}
+
+// formatParams renders the KDF cost parameters of h as text.
+func formatParams(h keyfile.Header) string {
+	if h.KDF == keyfile.Argon2id {
+		return fmt.Sprintf("t=%d m=%d p=%d", h.Params[0], h.Params[1], h.Params[2])
+	}
+	return fmt.Sprintf("N=%d r=%d p=%d", h.Params[0], h.Params[1], h.Params[2])
+}
+
+// sharePath returns the path of the share file with index i (from 0) for the
+// key file at path.
+func sharePath(path string, i int) string { return fmt.Sprintf("%s.share-%d", path, i+1) }
+
+func checkOverwrite(path string) error {
+	if writeFlags.Force {
+		return nil
+	}
+	kf, err := openKeyFile(path)
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, keyfile.ErrBadPacket) || errors.Is(err, keyfile.ErrFileTooLarge) {
+		return nil // nothing to lose
+	} else if err != nil {
+		return err
+	} else if kf.IsEmpty() {
+		return nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return fmt.Errorf("key file %q already exists (use --force to overwrite)", path)
+	}
+	fmt.Fprintf(os.Stderr, "Key file %q already exists. Overwrite? [y/N] ", path)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return nil
+	}
+	return errors.New("not overwriting existing key file")
+}
+
+func openKeyFile(path string) (*keyfile.File, error) { return keyfile.ReadFile(path) }
+
 func loadKeyFile(tag, path string) ([]byte, error) {
+	stop := func() {}
 	key, err := keyfile.LoadKey(path, func() (string, error) {
-		return getPassphrase(tag, false)
+		pp, err := getPassphrase(tag, false)
+		if err == nil {
+			stop = startProgress() // derivation follows immediately
+		}
+		return pp, err
 	})
+	stop()
 	if err != nil {
 		return nil, fmt.Errorf("load: %w", err)
 	}
 	return key, nil
 }
 
+var encodings = map[string]func([]byte) []byte{
+	"base64":    textEncoding(base64.StdEncoding.EncodeToString),
+	"base64url": textEncoding(base64.URLEncoding.EncodeToString),
+	"hex":       textEncoding(hex.EncodeToString),
+	"raw":       func(key []byte) []byte { return key },
+}
+
+func textEncoding(enc func([]byte) string) func([]byte) []byte {
+	return func(key []byte) []byte { return []byte(enc(key) + "\n") }
+}
+
 func decodeKey(s string) ([]byte, error) {
 	if s == "-" {
 		return io.ReadAll(os.Stdin)
@@ -207,14 +946,35 @@ func decodeKey(s string) ([]byte, error) {
 }
 
 func getPassphrase(tag string, confirm bool) (string, error) {
-	pp, err := getpass.Prompt(tag + "Passphrase: ")
+	return promptPassphrase(tag, confirm, flagPassphrase)
+}
+
+// getNewPassphrase prompts for a new passphrase that replaces one already read
+// by the same command, taking it from the --new-passphrase-* flags if set.
+func getNewPassphrase(tag string) (string, error) {
+	return promptPassphrase(tag, true, flagNewPassphrase)
+}
+
+func promptPassphrase(tag string, confirm bool, fromFlags func() (string, bool, error)) (string, error) {
+	pp, fromFlag, err := fromFlags()
 	if err != nil {
-		return "", fmt.Errorf("read passphrase: %w", err)
-	} else if pp == "" && confirm && !flags.EmptyOK {
+		return "", err
+	} else if !fromFlag {
+		pp, err = passphrases.ReadPassphrase(tag + "Passphrase: ")
+		if err != nil {
+			return "", fmt.Errorf("read passphrase: %w", err)
+		}
+	}
+	if pp == "" && confirm && !flags.EmptyOK {
 		return "", errors.New("empty passphrase")
 	}
-	if confirm {
-		cf, err := getpass.Prompt("Confirm " + tag + "passphrase: ")
+	if confirm && pp != "" {
+		if err := checkStrength(pp); err != nil {
+			return "", err
+		}
+	}
+	if confirm && !fromFlag {
+		cf, err := passphrases.ReadPassphrase("Confirm " + tag + "passphrase: ")
 		if err != nil {
 			return "", fmt.Errorf("read confirmation: %w", err)
 		} else if cf != pp {
@@ -224,6 +984,17 @@ func getPassphrase(tag string, confirm bool) (string, error) {
 	return pp, nil
 }
 
+func checkStrength(pp string) error {
+	st := keyfile.PassphraseStrength(pp)
+	if st.Strong() {
+		return nil
+	} else if flags.RequireStrong {
+		return fmt.Errorf("passphrase is too weak (about %.0f bits, want %d)", st.Bits, keyfile.StrongBits)
+	}
+	fmt.Fprintf(os.Stderr, "Warning: passphrase appears weak (about %.0f bits); consider a longer one\n", st.Bits)
+	return nil
+}
+
 func offerKey(env *command.Env, pipeFile string, key []byte) error {
 	fi, err := os.Stat(pipeFile)
 	if err == nil {
@@ -271,6 +1042,13 @@ func offerKey(env *command.Env, pipeFile string, key []byte) error {
 	return nil
 }
 
+func changed(ok bool, what string) string {
+	if ok {
+		return what
+	}
+	return "unchanged"
+}
+
 func checkSize(s string) (int, error) {
 	n, err := strconv.Atoi(s)
 	if err != nil {
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/creachadair/getpass"
+)
+
+// A PassphraseSource obtains passphrases from the user.
+type PassphraseSource interface {
+	// ReadPassphrase displays prompt and returns the passphrase entered in
+	// response, which should not be echoed.
+	ReadPassphrase(prompt string) (string, error)
+}
+
+// passphrases is the source used for all passphrase prompts, including
+// confirmation. It reads from the controlling terminal; code within this
+// package, such as a test, may replace it to supply passphrases another way.
+var passphrases PassphraseSource = terminalSource{}
+
+// terminalSource is a PassphraseSource that reads from the controlling
+// terminal.
+type terminalSource struct{}
+
+func (terminalSource) ReadPassphrase(prompt string) (string, error) { return getpass.Prompt(prompt) }
+
+// flagPassphrase returns the passphrase given by --passphrase-env or
+// --passphrase-file, and reports whether either flag was set. When a flag is
+// set, the user is not prompted, and no confirmation is required. Trailing
+// newlines are removed from a passphrase read from a file.
+func flagPassphrase() (string, bool, error) {
+	return readFlagPassphrase("passphrase", flags.PassphraseEnv, flags.PassphraseFile)
+}
+
+// flagNewPassphrase returns the passphrase given by --new-passphrase-env or
+// --new-passphrase-file, for commands that read a second, new passphrase after
+// the first. It reports an error if --passphrase-env or --passphrase-file is
+// set without either of these, so that the old passphrase is not silently
+// reused as the new one.
+func flagNewPassphrase() (string, bool, error) {
+	pp, ok, err := readFlagPassphrase("new-passphrase", flags.NewPassphraseEnv, flags.NewPassphraseFile)
+	if !ok && (flags.PassphraseEnv != "" || flags.PassphraseFile != "") {
+		return "", true, errors.New("--new-passphrase-env or --new-passphrase-file is required with --passphrase-env or --passphrase-file")
+	}
+	return pp, ok, err
+}
+
+func readFlagPassphrase(name, env, file string) (string, bool, error) {
+	switch {
+	case env != "" && file != "":
+		return "", true, fmt.Errorf("at most one of --%[1]s-env and --%[1]s-file may be set", name)
+	case env != "":
+		pp, ok := os.LookupEnv(env)
+		if !ok {
+			return "", true, fmt.Errorf("environment variable %q is not set", env)
+		}
+		return pp, true, nil
+	case file != "":
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", true, fmt.Errorf("read passphrase: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), true, nil
+	}
+	return "", false, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/creachadair/keyfile"
+	"github.com/creachadair/mds/mtest"
+	"github.com/google/go-cmp/cmp"
+)
+
+// scriptSource is a PassphraseSource that returns a fixed sequence of
+// responses, and records the prompts it was given.
+type scriptSource struct {
+	replies []string
+	prompts []string
+}
+
+func (s *scriptSource) ReadPassphrase(prompt string) (string, error) {
+	s.prompts = append(s.prompts, prompt)
+	if len(s.replies) == 0 {
+		return "", errors.New("no more replies")
+	}
+	pp := s.replies[0]
+	s.replies = s.replies[1:]
+	return pp, nil
+}
+
+func TestSetConfirm(t *testing.T) {
+	const passphrase = "correct horse battery staple"
+	key := []byte("the key to the kingdom")
+	dir := t.TempDir()
+
+	t.Run("Match", func(t *testing.T) {
+		src := &scriptSource{replies: []string{passphrase, passphrase}}
+		mtest.Swap[PassphraseSource](t, &passphrases, src)
+
+		path := filepath.Join(dir, "match.key")
+		if err := createKeyFile(path, key); err != nil {
+			t.Fatalf("createKeyFile: unexpected error: %v", err)
+		}
+		want := []string{"Passphrase: ", "Confirm passphrase: "}
+		if diff := cmp.Diff(want, src.prompts); diff != "" {
+			t.Errorf("Prompts (-want, +got):\n%s", diff)
+		}
+		got, err := keyfile.LoadKey(path, func() (string, error) { return passphrase, nil })
+		if err != nil {
+			t.Fatalf("LoadKey: unexpected error: %v", err)
+		} else if diff := cmp.Diff(key, got); diff != "" {
+			t.Errorf("LoadKey (-want, +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		src := &scriptSource{replies: []string{passphrase, "incorrect horse battery staple"}}
+		mtest.Swap[PassphraseSource](t, &passphrases, src)
+
+		path := filepath.Join(dir, "mismatch.key")
+		if err := createKeyFile(path, key); err == nil {
+			t.Error("createKeyFile: got nil, want error for mismatched confirmation")
+		}
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Stat %q: got %v, want %v", path, err, os.ErrNotExist)
+		}
+	})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// progressDelay is how long a key derivation must run before the progress
+// indicator is shown, so that fast derivations do not flicker.
+const progressDelay = 250 * time.Millisecond
+
+func showProgress() bool {
+	switch flags.Progress {
+	case "always":
+		return true
+	case "never":
+		return false
+	default:
+		return term.IsTerminal(int(os.Stderr.Fd()))
+	}
+}
+
+// startProgress displays a spinner and elapsed time on stderr until the
+// returned function is called. The scrypt derivation is not incrementally
+// observable, so this shows only that work is underway, not how much
+// remains.
+func startProgress() (stop func()) {
+	if !showProgress() {
+		return func() {}
+	}
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		start := time.Now()
+		select {
+		case <-done:
+			return
+		case <-time.After(progressDelay):
+		}
+		tick := time.NewTicker(100 * time.Millisecond)
+		defer tick.Stop()
+		const frames = `|/-\`
+		for i := 0; ; i++ {
+			fmt.Fprintf(os.Stderr, "\r%c Deriving key... %.1fs", frames[i%len(frames)],
+				time.Since(start).Seconds())
+			select {
+			case <-done:
+				fmt.Fprint(os.Stderr, "\r\033[K") // clear the line
+				return
+			case <-tick.C:
+			}
+		}
+	}()
+	return func() { close(done); <-finished }
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/creachadair/keyfile"
+	"golang.org/x/sys/unix"
+)
+
+// socketTarget reports whether target names a socket rather than a pipe, and
+// if so returns its network ("unix" or "tcp") and address.
+func socketTarget(target string) (network, addr string, ok bool) {
+	for _, network := range []string{"unix", "tcp"} {
+		if addr, ok := strings.CutPrefix(target, network+"://"); ok {
+			return network, addr, true
+		}
+	}
+	return "", "", false
+}
+
+// listenSocket listens on the given network and address. A Unix-domain socket
+// is created with mode 0600. The listener is closed when ctx ends, which
+// unblocks any pending Accept; the caller must also close it when done.
+func listenSocket(ctx context.Context, network, addr string) (net.Listener, error) {
+	var lc net.ListenConfig
+	if network == "unix" {
+		// Set the umask while binding, so the socket is never accessible to
+		// other users, even briefly. Changing the mode afterward would leave a
+		// window in which another process could connect.
+		old := unix.Umask(0177)
+		defer unix.Umask(old)
+	}
+	lst, err := lc.Listen(ctx, network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+	context.AfterFunc(ctx, func() { lst.Close() })
+	return lst, nil
+}
+
+// offerSocket listens on the given network and address, accepts a single
+// connection, writes key to it, and closes it.
+func offerSocket(ctx context.Context, network, addr string, key []byte) error {
+	lst, err := listenSocket(ctx, network, addr)
+	if err != nil {
+		return err
+	}
+	defer lst.Close() // for a Unix socket, this also removes the socket file
+	fmt.Fprintf(os.Stderr, "Offering key at %s://%s\n", network, lst.Addr())
+
+	// Accepting a connection will block waiting for a client. If the context
+	// ends before we get one, the listener is closed to unblock the accept.
+	conn, err := lst.Accept()
+	if err := ctx.Err(); err != nil {
+		if conn != nil {
+			conn.Close()
+		}
+		return err
+	} else if err != nil {
+		return fmt.Errorf("accept: %w", err)
+	}
+
+	// Reaching here, we got a real client.
+	_, werr := conn.Write(key)
+	if err := errors.Join(werr, conn.Close()); err != nil {
+		return fmt.Errorf("offering key: %w", err)
+	}
+	return nil
+}
+
+// serveAgent serves keys from a on a Unix-domain socket at socketPath until
+// ctx ends.
+func serveAgent(ctx context.Context, a *keyfile.Agent, socketPath string) error {
+	lst, err := listenSocket(ctx, "unix", socketPath)
+	if err != nil {
+		return err
+	}
+	defer lst.Close()
+	fmt.Fprintf(os.Stderr, "Agent serving %d keys at %s\n", len(a.Paths()), socketPath)
+	if err := a.Serve(lst); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("serve: %w", err)
+	}
+	return nil
+}
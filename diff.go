@@ -0,0 +1,73 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package keyfile
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// A Report describes the differences between two keyfiles, as computed by
+// Diff. It never contains either secret.
+type Report struct {
+	SecretChanged bool // the decrypted secrets differ
+
+	// Fingerprints of the old and new secrets. A fingerprint is a short hash
+	// of the secret, suitable for telling secrets apart but not for
+	// recovering them. Note that a fingerprint of a low-entropy secret (such
+	// as a short password) can be checked against guesses.
+	OldFingerprint, NewFingerprint string
+
+	// Lengths in bytes of the old and new secrets.
+	OldLen, NewLen int
+
+	SaltChanged  bool // the key-generation salt differs
+	NonceChanged bool // the AEAD nonce differs
+
+	// The old and new key derivation functions, ciphers, and KDF cost
+	// parameters, as reported by Header.
+	OldKDF, NewKDF       KDF
+	OldCipher, NewCipher Cipher
+	OldParams, NewParams [3]int
+}
+
+// Diff decrypts a and b using the given passphrase and reports how they
+// differ. Both files must decrypt with the same passphrase.
+func Diff(a, b *File, passphrase string) (Report, error) {
+	ak, err := a.Get(passphrase)
+	if err != nil {
+		return Report{}, fmt.Errorf("old: %w", err)
+	}
+	defer clear(ak)
+	bk, err := b.Get(passphrase)
+	if err != nil {
+		return Report{}, fmt.Errorf("new: %w", err)
+	}
+	defer clear(bk)
+	ah, bh := a.Header(), b.Header()
+	return Report{
+		SecretChanged:  !bytes.Equal(ak, bk),
+		OldFingerprint: fingerprint(ak),
+		NewFingerprint: fingerprint(bk),
+		OldLen:         len(ak),
+		NewLen:         len(bk),
+		SaltChanged:    !bytes.Equal(a.salt, b.salt),
+		NonceChanged:   !bytes.Equal(a.nonce, b.nonce),
+		OldKDF:         ah.KDF,
+		NewKDF:         bh.KDF,
+		OldCipher:      ah.Cipher,
+		NewCipher:      bh.Cipher,
+		OldParams:      ah.Params,
+		NewParams:      bh.Params,
+	}, nil
+}
+
+// fingerprint returns a short hex-encoded hash of key.
+func fingerprint(key []byte) string {
+	h := sha256.New()
+	h.Write([]byte("keyfile fingerprint\x00"))
+	h.Write(key)
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
@@ -0,0 +1,70 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package keyfile_test
+
+import (
+	crand "crypto/rand"
+	"io"
+	mrand "math/rand"
+	"testing"
+
+	"github.com/creachadair/keyfile"
+	"github.com/creachadair/mds/mtest"
+)
+
+func TestDiff(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20241016091047)))
+	const passphrase = "spot the difference"
+
+	mustSet := func(secret string) *keyfile.File {
+		t.Helper()
+		f := keyfile.New()
+		if err := f.Set(passphrase, []byte(secret)); err != nil {
+			t.Fatalf("Set %q: unexpected error: %v", secret, err)
+		}
+		return f
+	}
+	a := mustSet("alpha")
+	b := mustSet("alpha") // same secret, fresh salt
+	c := mustSet("charlie")
+
+	if r, err := keyfile.Diff(a, a, passphrase); err != nil {
+		t.Errorf("Diff(a, a): unexpected error: %v", err)
+	} else if r.SecretChanged || r.SaltChanged || r.NonceChanged || r.OldFingerprint != r.NewFingerprint {
+		t.Errorf("Diff(a, a): got %+v, want no changes", r)
+	}
+
+	if r, err := keyfile.Diff(a, b, passphrase); err != nil {
+		t.Errorf("Diff(a, b): unexpected error: %v", err)
+	} else if r.SecretChanged || !r.SaltChanged || r.OldFingerprint != r.NewFingerprint {
+		t.Errorf("Diff(a, b): got %+v, want salt changed, secret unchanged", r)
+	}
+
+	if r, err := keyfile.Diff(a, c, passphrase); err != nil {
+		t.Errorf("Diff(a, c): unexpected error: %v", err)
+	} else if !r.SecretChanged || r.OldFingerprint == r.NewFingerprint || r.OldLen != 5 || r.NewLen != 7 {
+		t.Errorf("Diff(a, c): got %+v, want secret changed from 5 to 7 bytes", r)
+	}
+
+	if r, err := keyfile.Diff(a, b, passphrase); err != nil {
+		t.Errorf("Diff(a, b): unexpected error: %v", err)
+	} else if r.OldKDF != keyfile.Scrypt || r.NewKDF != keyfile.Scrypt || r.OldCipher != r.NewCipher || r.OldParams != r.NewParams {
+		t.Errorf("Diff(a, b): got %+v, want same KDF, cipher, and params", r)
+	}
+
+	d := keyfile.New(keyfile.WithCipher(keyfile.ChaCha20Poly1305), keyfile.WithScryptP(2))
+	if err := d.Set(passphrase, []byte("alpha")); err != nil {
+		t.Fatalf("Set: unexpected error: %v", err)
+	}
+	if r, err := keyfile.Diff(a, d, passphrase); err != nil {
+		t.Errorf("Diff(a, d): unexpected error: %v", err)
+	} else if r.OldCipher != keyfile.AES256GCM || r.NewCipher != keyfile.ChaCha20Poly1305 {
+		t.Errorf("Diff(a, d): got cipher %v → %v, want %v → %v", r.OldCipher, r.NewCipher, keyfile.AES256GCM, keyfile.ChaCha20Poly1305)
+	} else if r.OldParams[2] != 1 || r.NewParams[2] != 2 {
+		t.Errorf("Diff(a, d): got params %v → %v, want p=1 → p=2", r.OldParams, r.NewParams)
+	}
+
+	if r, err := keyfile.Diff(a, c, "wrong"); err == nil {
+		t.Errorf("Diff with wrong passphrase: got %+v, want error", r)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package keyfile
+
+import (
+	crand "crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// ErrBadCiphertext is reported by Decrypt when the ciphertext is truncated,
+// corrupted, or was not encrypted with the key stored in the File.
+var ErrBadCiphertext = errors.New("invalid ciphertext")
+
+// Encrypt decrypts the key from f using the given passphrase, and uses it as
+// an AES-256-GCM key to encrypt plaintext. The result consists of a random
+// nonce followed by the sealed plaintext. The stored key must be exactly 32
+// bytes long, or Encrypt reports ErrSecretWrongSize.
+func (f *File) Encrypt(passphrase string, plaintext []byte) ([]byte, error) {
+	key, err := f.Get32(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(key[:])
+	aead, err := newAEAD(AES256GCM, key[:])
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := crand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Decrypt decrypts the key from f using the given passphrase, and uses it to
+// decrypt a ciphertext produced by Encrypt. It reports ErrBadPassphrase if the
+// passphrase is incorrect, and ErrBadCiphertext if the ciphertext is truncated
+// or fails authentication.
+func (f *File) Decrypt(passphrase string, ciphertext []byte) ([]byte, error) {
+	key, err := f.Get32(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(key[:])
+	aead, err := newAEAD(AES256GCM, key[:])
+	if err != nil {
+		return nil, err
+	}
+	if n := aead.NonceSize() + aead.Overhead(); len(ciphertext) < n {
+		return nil, fmt.Errorf("%w: got %d bytes, need at least %d", ErrBadCiphertext, len(ciphertext), n)
+	}
+	nonce, data := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: authentication failed", ErrBadCiphertext)
+	}
+	return plain, nil
+}
@@ -0,0 +1,75 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package keyfile_test
+
+import (
+	crand "crypto/rand"
+	"errors"
+	"io"
+	mrand "math/rand"
+	"testing"
+
+	"github.com/creachadair/keyfile"
+	"github.com/creachadair/mds/mtest"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20261016124410)))
+	const passphrase = "data at rest"
+
+	f := keyfile.New(keyfile.WithScryptN(1 << 10))
+	if _, err := f.Random(passphrase, 32); err != nil {
+		t.Fatalf("Random: unexpected error: %v", err)
+	}
+
+	for _, msg := range []string{"", "a", "a longer message that spans more than one block"} {
+		ct, err := f.Encrypt(passphrase, []byte(msg))
+		if err != nil {
+			t.Fatalf("Encrypt(%q): unexpected error: %v", msg, err)
+		}
+		if got, err := f.Decrypt(passphrase, ct); err != nil {
+			t.Errorf("Decrypt(%q): unexpected error: %v", msg, err)
+		} else if diff := cmp.Diff(msg, string(got)); diff != "" {
+			t.Errorf("Decrypt (-want, +got):\n%s", diff)
+		}
+
+		// Each encryption uses a fresh nonce.
+		if again, err := f.Encrypt(passphrase, []byte(msg)); err != nil {
+			t.Errorf("Encrypt(%q): unexpected error: %v", msg, err)
+		} else if string(again) == string(ct) {
+			t.Errorf("Encrypt(%q) twice produced the same output", msg)
+		}
+	}
+
+	ct, err := f.Encrypt(passphrase, []byte("tamper with me"))
+	if err != nil {
+		t.Fatalf("Encrypt: unexpected error: %v", err)
+	}
+	bad := append([]byte(nil), ct...)
+	bad[len(bad)-1] ^= 1
+	for _, test := range []struct {
+		name string
+		pp   string
+		ct   []byte
+		want error
+	}{
+		{"WrongPassphrase", "wrong", ct, keyfile.ErrBadPassphrase},
+		{"Empty", passphrase, nil, keyfile.ErrBadCiphertext},
+		{"Truncated", passphrase, ct[:27], keyfile.ErrBadCiphertext},
+		{"Tampered", passphrase, bad, keyfile.ErrBadCiphertext},
+	} {
+		if got, err := f.Decrypt(test.pp, test.ct); !errors.Is(err, test.want) {
+			t.Errorf("Decrypt %s: got %q, %v; want %v", test.name, got, err, test.want)
+		}
+	}
+
+	// The stored key must be suitable for AES-256.
+	short := keyfile.New(keyfile.WithScryptN(1 << 10))
+	if _, err := short.Random(passphrase, 16); err != nil {
+		t.Fatalf("Random: unexpected error: %v", err)
+	}
+	if got, err := short.Encrypt(passphrase, []byte("x")); !errors.Is(err, keyfile.ErrSecretWrongSize) {
+		t.Errorf("Encrypt with short key: got %q, %v; want %v", got, err, keyfile.ErrSecretWrongSize)
+	}
+}
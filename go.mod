@@ -5,6 +5,7 @@ go 1.23
 toolchain go1.23.1
 
 require (
+	filippo.io/age v1.2.1
 	github.com/creachadair/atomicfile v0.3.7
 	github.com/creachadair/command v0.1.21
 	github.com/creachadair/flax v0.0.4
@@ -13,13 +14,13 @@ require (
 	github.com/google/go-cmp v0.6.0
 	golang.org/x/crypto v0.32.0
 	golang.org/x/sys v0.29.0
+	golang.org/x/term v0.28.0
 	honnef.co/go/tools v0.5.1
 )
 
 require (
 	golang.org/x/exp/typeparams v0.0.0-20231108232855-2478ac86f678 // indirect
-	golang.org/x/mod v0.17.0 // indirect
+	golang.org/x/mod v0.18.0 // indirect
 	golang.org/x/sync v0.7.0 // indirect
-	golang.org/x/term v0.28.0 // indirect
-	golang.org/x/tools v0.21.1-0.20240531212143-b6235391adb3 // indirect
+	golang.org/x/tools v0.22.0 // indirect
 )
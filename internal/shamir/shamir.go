@@ -0,0 +1,135 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+// Package shamir implements Shamir's secret sharing scheme over GF(2^8).
+//
+// A secret is split byte-wise: each byte of the secret is the constant term
+// of a random polynomial of degree k-1, and each share holds the value of
+// every such polynomial at a distinct nonzero point x. Any k shares determine
+// the polynomials, and hence the secret; fewer than k reveal nothing about it.
+//
+// A share is one byte longer than the secret. The last byte of each share is
+// its x coordinate, so that shares can be combined in any order.
+package shamir
+
+import (
+	crand "crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// Split splits secret into n shares, any k of which are sufficient to
+// reconstruct it. It is an error if secret is empty, or if k and n do not
+// satisfy 2 ≤ k ≤ n ≤ 255.
+func Split(secret []byte, n, k int) ([][]byte, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("empty secret")
+	} else if k < 2 || k > n || n > 255 {
+		return nil, fmt.Errorf("invalid parameters: need 2 ≤ k (%d) ≤ n (%d) ≤ 255", k, n)
+	}
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][len(secret)] = byte(i + 1) // x coordinate
+	}
+
+	coeff := make([]byte, k) // coeff[0] is the intercept
+	defer clear(coeff)
+	for j, b := range secret {
+		coeff[0] = b
+		if _, err := crand.Read(coeff[1:]); err != nil {
+			return nil, err
+		}
+		for _, s := range shares {
+			s[j] = eval(coeff, s[len(secret)])
+		}
+	}
+	return shares, nil
+}
+
+// Combine reconstructs a secret from shares generated by Split. The caller
+// must provide at least as many shares as the threshold given to Split;
+// otherwise the result will be garbage. Combine reports an error if the
+// shares are malformed or inconsistent.
+func Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, errors.New("at least two shares are required")
+	}
+	size := len(shares[0])
+	if size < 2 {
+		return nil, errors.New("invalid share length")
+	}
+	xs := make([]byte, len(shares))
+	seen := make(map[byte]bool)
+	for i, s := range shares {
+		if len(s) != size {
+			return nil, errors.New("shares have different lengths")
+		}
+		x := s[size-1]
+		if x == 0 {
+			return nil, fmt.Errorf("share %d has invalid coordinate", i+1)
+		} else if seen[x] {
+			return nil, fmt.Errorf("duplicate share coordinate %d", x)
+		}
+		seen[x] = true
+		xs[i] = x
+	}
+
+	// Compute the Lagrange basis for interpolation at x = 0.  These depend
+	// only on the coordinates, so they are shared by all the secret bytes.
+	basis := make([]byte, len(xs))
+	for i, xi := range xs {
+		num, den := byte(1), byte(1)
+		for j, xj := range xs {
+			if i != j {
+				num = mul(num, xj)
+				den = mul(den, xi^xj)
+			}
+		}
+		basis[i] = mul(num, inv(den))
+	}
+
+	secret := make([]byte, size-1)
+	for j := range secret {
+		var v byte
+		for i, s := range shares {
+			v ^= mul(basis[i], s[j])
+		}
+		secret[j] = v
+	}
+	return secret, nil
+}
+
+// eval evaluates the polynomial with the given coefficients at x, using
+// Horner's rule.
+func eval(coeff []byte, x byte) byte {
+	var v byte
+	for i := len(coeff) - 1; i >= 0; i-- {
+		v = mul(v, x) ^ coeff[i]
+	}
+	return v
+}
+
+// mul returns the product of a and b in GF(2^8) modulo the AES polynomial
+// x^8 + x^4 + x^3 + x + 1. It does not branch on its inputs.
+func mul(a, b byte) byte {
+	var p byte
+	for range 8 {
+		p ^= a & -(b & 1)
+		hi := a >> 7
+		a = (a << 1) ^ (0x1b & -hi)
+		b >>= 1
+	}
+	return p
+}
+
+// inv returns the multiplicative inverse of a in GF(2^8), or 0 if a == 0.
+// Since the multiplicative group has order 255, a^-1 = a^254.
+func inv(a byte) byte {
+	r := a
+	for range 6 {
+		a = mul(a, a)
+		r = mul(r, a)
+	}
+	return mul(r, r)
+}
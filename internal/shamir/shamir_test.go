@@ -0,0 +1,99 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package shamir
+
+import (
+	"math/rand/v2"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestField(t *testing.T) {
+	for a := range 256 {
+		if got := mul(byte(a), 1); got != byte(a) {
+			t.Errorf("mul(%d, 1): got %d, want %d", a, got, a)
+		}
+		if a == 0 {
+			continue
+		}
+		if got := mul(byte(a), inv(byte(a))); got != 1 {
+			t.Errorf("mul(%d, inv(%d)): got %d, want 1", a, a, got)
+		}
+	}
+	// Known product from FIPS 197, section 4.2.
+	if got := mul(0x57, 0x83); got != 0xc1 {
+		t.Errorf("mul(0x57, 0x83): got %#x, want 0xc1", got)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	secret := []byte("the quick brown fox jumps over the lazy dog")
+	for _, test := range []struct{ n, k int }{
+		{2, 2}, {3, 2}, {5, 3}, {10, 10}, {255, 7},
+	} {
+		shares, err := Split(secret, test.n, test.k)
+		if err != nil {
+			t.Fatalf("Split(%d, %d): unexpected error: %v", test.n, test.k, err)
+		} else if len(shares) != test.n {
+			t.Fatalf("Split(%d, %d): got %d shares, want %d", test.n, test.k, len(shares), test.n)
+		}
+
+		// Any k shares in any order should reconstruct the secret.
+		for range 5 {
+			perm := rand.Perm(test.n)[:test.k]
+			var sub [][]byte
+			for _, i := range perm {
+				sub = append(sub, shares[i])
+			}
+			got, err := Combine(sub)
+			if err != nil {
+				t.Errorf("Combine %v: unexpected error: %v", perm, err)
+			} else if diff := cmp.Diff(secret, got); diff != "" {
+				t.Errorf("Combine %v: wrong secret (-want, +got):\n%s", perm, diff)
+			}
+		}
+
+		// Fewer than k shares should not reconstruct the secret.
+		if got, err := Combine(shares[:test.k-1]); err == nil && string(got) == string(secret) {
+			t.Errorf("Combine with %d < %d shares recovered the secret", test.k-1, test.k)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		secret string
+		n, k   int
+	}{
+		{"EmptySecret", "", 3, 2},
+		{"ThresholdTooSmall", "x", 3, 1},
+		{"ThresholdTooLarge", "x", 3, 4},
+		{"TooManyShares", "x", 256, 2},
+	} {
+		if got, err := Split([]byte(test.secret), test.n, test.k); err == nil {
+			t.Errorf("Split %s: got %q, want error", test.name, got)
+		}
+	}
+
+	for _, test := range []struct {
+		name   string
+		shares []string
+	}{
+		{"NoShares", nil},
+		{"OneShare", []string{"a\x01"}},
+		{"ShortShare", []string{"\x01", "\x02"}},
+		{"LengthMismatch", []string{"ab\x01", "a\x02"}},
+		{"ZeroCoordinate", []string{"a\x00", "b\x01"}},
+		{"DuplicateCoordinate", []string{"a\x01", "b\x01"}},
+	} {
+		var shares [][]byte
+		for _, s := range test.shares {
+			shares = append(shares, []byte(s))
+		}
+		if got, err := Combine(shares); err == nil {
+			t.Errorf("Combine %s: got %q, want error", test.name, got)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package keyfile
+
+import (
+	"fmt"
+	"math/bits"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// A KDF identifies the function used to derive an encryption key from a
+// passphrase. The zero value is Scrypt.
+type KDF byte
+
+const (
+	Scrypt   KDF = 0 // scrypt (the default)
+	Argon2id KDF = 1 // Argon2id, as defined by RFC 9106
+)
+
+func (k KDF) String() string {
+	switch k {
+	case Scrypt:
+		return "scrypt"
+	case Argon2id:
+		return "argon2id"
+	default:
+		return fmt.Sprintf("KDF(%d)", byte(k))
+	}
+}
+
+// WithKDF selects the key derivation function a File uses when a secret is
+// stored by Set. It panics if k is not a known KDF.
+func WithKDF(k KDF) Option {
+	if _, err := defaultParams(k); err != nil {
+		panic(err)
+	}
+	return func(f *File) { f.params = kdfParams{kdf: k} }
+}
+
+// WithScryptN sets the scrypt CPU and memory cost N a File uses when a secret
+// is stored by Set, instead of the default. It panics if N is not a power of 2
+// greater than 1. It has no effect on a File that does not use scrypt.
+func WithScryptN(N int) Option {
+	if N <= 1 || N&(N-1) != 0 {
+		panic(fmt.Sprintf("invalid scrypt N = %d (must be a power of 2 greater than 1)", N))
+	}
+	logN := byte(bits.TrailingZeros(uint(N)))
+	return func(f *File) { f.cost.logN = logN }
+}
+
+// WithScryptR sets the scrypt block size r a File uses when a secret is stored
+// by Set, instead of the default. It panics if r is not between 1 and 255.
+// It has no effect on a File that does not use scrypt.
+func WithScryptR(r int) Option {
+	if r < 1 || r > 255 {
+		panic(fmt.Sprintf("invalid scrypt r = %d (must be 1 to 255)", r))
+	}
+	return func(f *File) { f.cost.r = byte(r) }
+}
+
+// WithScryptP sets the scrypt parallelism p a File uses when a secret is
+// stored by Set, instead of the default. It panics if p is not between 1 and
+// 255. It has no effect on a File that does not use scrypt.
+func WithScryptP(p int) Option {
+	if p < 1 || p > 255 {
+		panic(fmt.Sprintf("invalid scrypt p = %d (must be 1 to 255)", p))
+	}
+	return func(f *File) { f.cost.p = byte(p) }
+}
+
+const (
+	maxScryptLogN   = 30      // largest accepted log2(N)
+	maxScryptMemory = 1 << 30 // largest accepted 128*r*N, in bytes
+	maxArgonLogMem  = 20      // largest accepted log2(memory), in KiB
+)
+
+// kdfParams are the key derivation settings for a packet. Only the parameters
+// for the selected KDF are meaningful.
+type kdfParams struct {
+	kdf    KDF
+	scrypt scryptParams
+	argon  argonParams
+}
+
+// scryptParams are the cost parameters for scrypt key derivation.
+type scryptParams struct {
+	logN, r, p byte // N == 1<<logN
+}
+
+// argonParams are the cost parameters for Argon2id key derivation.
+type argonParams struct {
+	time    byte // number of passes
+	logMem  byte // memory == 1<<logMem KiB
+	threads byte // degree of parallelism
+}
+
+var (
+	// legacyParams are the parameters implied by a packet with no stored
+	// parameters.
+	legacyParams = kdfParams{kdf: Scrypt, scrypt: scryptParams{logN: 15, r: 8, p: 1}}
+
+	// defaultScrypt and defaultArgon are the parameters used for new packets.
+	defaultScrypt = scryptParams{logN: 15, r: 8, p: 1}
+	defaultArgon  = argonParams{time: 3, logMem: 16, threads: 4} // 64 MiB
+)
+
+// defaultParams returns the parameters used for new packets with the given
+// KDF, or an error if k is not a known KDF.
+func defaultParams(k KDF) (kdfParams, error) {
+	switch k {
+	case Scrypt:
+		return kdfParams{kdf: k, scrypt: defaultScrypt}, nil
+	case Argon2id:
+		return kdfParams{kdf: k, argon: defaultArgon}, nil
+	default:
+		return kdfParams{}, fmt.Errorf("unknown KDF %d", byte(k))
+	}
+}
+
+// decodeParams decodes KDF parameters from their stored representation.
+// It reports an error if the result is not valid.
+func decodeParams(k KDF, b [3]byte) (kdfParams, error) {
+	kp := kdfParams{kdf: k}
+	switch k {
+	case Scrypt:
+		kp.scrypt = scryptParams{logN: b[0], r: b[1], p: b[2]}
+	case Argon2id:
+		kp.argon = argonParams{time: b[0], logMem: b[1], threads: b[2]}
+	default:
+		return kp, fmt.Errorf("unknown KDF %d", byte(k))
+	}
+	return kp, kp.check()
+}
+
+// encode returns the stored representation of the parameters for kp.kdf.
+func (kp kdfParams) encode() [3]byte {
+	if kp.kdf == Argon2id {
+		return [3]byte{kp.argon.time, kp.argon.logMem, kp.argon.threads}
+	}
+	return [3]byte{kp.scrypt.logN, kp.scrypt.r, kp.scrypt.p}
+}
+
+// check reports an error if kp are not valid parameters, or if they would
+// require an unreasonable amount of memory to derive a key.
+func (kp kdfParams) check() error {
+	switch kp.kdf {
+	case Scrypt:
+		sp := kp.scrypt
+		if sp.logN < 1 || sp.logN > maxScryptLogN {
+			return fmt.Errorf("scrypt log2(N) = %d out of range", sp.logN)
+		} else if sp.r == 0 || sp.p == 0 {
+			return fmt.Errorf("scrypt r = %d, p = %d must be positive", sp.r, sp.p)
+		} else if 128*uint64(sp.r)<<sp.logN > maxScryptMemory {
+			return fmt.Errorf("scrypt N = 2^%d, r = %d requires too much memory", sp.logN, sp.r)
+		}
+	case Argon2id:
+		ap := kp.argon
+		if ap.time == 0 || ap.threads == 0 {
+			return fmt.Errorf("argon2id time = %d, threads = %d must be positive", ap.time, ap.threads)
+		} else if ap.logMem > maxArgonLogMem || 1<<ap.logMem < 8*uint32(ap.threads) {
+			return fmt.Errorf("argon2id log2(memory) = %d out of range", ap.logMem)
+		}
+	default:
+		return fmt.Errorf("unknown KDF %d", byte(kp.kdf))
+	}
+	return nil
+}
+
+// weak reports whether kp has a lower cost than the defaults for its KDF.
+func (kp kdfParams) weak() bool {
+	if kp.kdf == Argon2id {
+		return kp.argon.time < defaultArgon.time || kp.argon.logMem < defaultArgon.logMem
+	}
+	// Compare the memory (128·r·N bytes) and total work (proportional to
+	// r·N·p), so that a larger N does not hide a smaller r, or vice versa.
+	sp, dp := kp.scrypt, defaultScrypt
+	mem, defMem := uint64(sp.r)<<sp.logN, uint64(dp.r)<<dp.logN
+	return mem < defMem || mem*uint64(sp.p) < defMem*uint64(dp.p)
+}
+
+// deriveKey derives an encryption key from the passphrase and salt.
+func (kp kdfParams) deriveKey(passphrase string, salt []byte) ([]byte, error) {
+	pw := []byte(passphrase)
+	defer clear(pw)
+	switch kp.kdf {
+	case Scrypt:
+		sp := kp.scrypt
+		ckey, err := scrypt.Key(pw, salt, 1<<sp.logN, int(sp.r), int(sp.p), aesKeyBytes)
+		if err != nil {
+			return nil, fmt.Errorf("scrypt: %w", err)
+		}
+		return ckey, nil
+	case Argon2id:
+		ap := kp.argon
+		return argon2.IDKey(pw, salt, uint32(ap.time), 1<<ap.logMem, ap.threads, aesKeyBytes), nil
+	default:
+		return nil, fmt.Errorf("unknown KDF %d", byte(kp.kdf))
+	}
+}
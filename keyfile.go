@@ -4,32 +4,59 @@
 // as encryption keys in a persistent format protected by a passphrase.
 //
 // Each secret is stored in a binary packet, inside which the secret is
-// encrypted and authenticated with AES-256 in Galois Counter Mode (GCM). The
-// encryption key is derived from a user passphrase using the scrypt algorithm.
+// encrypted and authenticated with AES-256 in Galois Counter Mode (GCM) (by
+// default) or ChaCha20-Poly1305. The encryption key is derived from a user
+// passphrase using the scrypt algorithm (by default) or Argon2id.
 //
 // The binary packet is structured as follows:
 //
 //	Pos          Len     Description
-//	0            3       Format tag, "KF\x02" == "\x4b\x46\x02"
+//	0            3       Format tag, "KF\x03" == "\x4b\x46\x03"
 //	3            1       Length of key generation salt in bytes (slen)
-//	4            1       Length of GCM nonce in bytes (nlen)
-//	5            slen    Key generation salt
-//	5+slen       nlen    GCM nonce
-//	5+slen+nlen  dlen    The encrypted data packet (to end)
+//	4            1       Length of AEAD nonce in bytes (nlen)
+//	5            1       Algorithm selector (see below)
+//	6            3       KDF parameters (see below)
+//	9            clen    Comment (optional, see below)
+//	9+clen       slen    Key generation salt
+//	9+clen+slen  nlen    AEAD nonce
+//	...          dlen    The encrypted data packet (to end)
 //
-// The data packet is encrypteed with AES-256 in GCM.
+// The low 3 bits of the algorithm selector choose the KDF (0 = scrypt,
+// 1 = Argon2id), and the high 4 bits choose the AEAD used to encrypt the data
+// packet (0 = AES-256-GCM, 1 = ChaCha20-Poly1305). If bit 3 (0x08) is set,
+// the packet has a comment, stored as a 1-byte length followed by that many
+// bytes of text, so that clen is 1 plus the length of the text. Otherwise
+// clen is 0. The comment is not encrypted, but it is authenticated along with
+// the data packet.
+//
+// For scrypt, the KDF parameters are log2(N), r, and p. For Argon2id, they
+// are the number of passes, log2 of the memory size in KiB, and the number
+// of threads.
+//
+// Parse also accepts the older "KF\x02" format, which omits the selector, the
+// parameters, and the comment, and always uses scrypt with N=2^15, r=8, p=1
+// and AES-256-GCM. Encode preserves the format of a parsed packet; Set always
+// writes the current format.
 package keyfile
 
 import (
 	"bytes"
-	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	crand "crypto/rand"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
-	"golang.org/x/crypto/scrypt"
+	"github.com/creachadair/atomicfile"
+	"golang.org/x/crypto/hkdf"
 )
 
 var (
@@ -41,85 +68,494 @@ var (
 
 	// ErrBadPacket is reported when parsing an invalid keyfile packet.
 	ErrBadPacket = errors.New("parse: bad packet")
+
+	// ErrSecretWrongSize is reported by Get16 and Get32 when the stored
+	// secret does not have the requested length.
+	ErrSecretWrongSize = errors.New("secret has the wrong size")
+
+	// ErrFileTooLarge is reported by LoadKeyLimit and File.ReadFrom when the
+	// input exceeds the size limit.
+	ErrFileTooLarge = errors.New("file is too large")
+
+	// ErrNotRegularFile is reported by LoadKey, LoadKeyLimit, and LoadKeyFS
+	// when the path names a directory, device, pipe, or other non-regular
+	// file.
+	ErrNotRegularFile = errors.New("not a regular file")
+
+	// ErrExists is reported by SaveKeyIfAbsent, and by SaveKeyOptions with
+	// NoOverwrite set, when the target path already exists.
+	ErrExists = errors.New("file already exists")
+)
+
+// DefaultMaxFileSize is the maximum size in bytes of a keyfile that LoadKey
+// will read. A legitimate keyfile is much smaller than this.
+const DefaultMaxFileSize = 4 << 20
+
+// Sizes in bytes of the key-generation salt. Set uses DefaultSaltBytes;
+// SetSaltSize accepts sizes from MinSaltBytes to MaxSaltBytes. The salt size
+// is recorded in the packet, so Get does not need to know it in advance.
+const (
+	DefaultSaltBytes = 16
+	MinSaltBytes     = 8
+	MaxSaltBytes     = 255
 )
 
+// MaxCommentBytes is the maximum length in bytes of a comment (see SetComment).
+const MaxCommentBytes = 255
+
 const (
-	aesKeyBytes      = 32 // for AES-256
-	keySaltBytes     = 16 // size of random salt for scrypt
-	scryptWorkFactor = 1 << 15
+	aesKeyBytes = 32 // for AES-256
+
+	magic          = "KF" // format magic number, followed by a version byte
+	legacyVersion  = 2    // scrypt parameters are implied
+	currentVersion = 3    // KDF selector and parameters are stored
 
-	magic = "KF\x02" // format magic number
+	maxHeaderBytes = len(magic) + 8 // version through comment length, excluding the comment
+
+	kdfMask     = 0x07 // selector bits for the KDF
+	commentFlag = 0x08 // selector bit indicating a comment is present
 )
 
 // A File represents a keyfile. A zero value is ready for use.
+//
+// Methods that only read a File, such as Get, Encode, and IsEmpty, are safe
+// for concurrent use by multiple goroutines. Methods that replace its contents,
+// such as Set and Random, require exclusive access: the caller must ensure no
+// other method is running on the same File concurrently.
 type File struct {
-	salt  []byte // key-generation salt
-	nonce []byte // AEAD nonce
-	data  []byte // encrypted data packet
+	version byte         // packet format version; 0 means current
+	params  kdfParams    // key derivation parameters
+	cipher  Cipher       // AEAD construction
+	cost    scryptParams // scrypt parameters for new packets; zero means default
+	comment string       // plaintext comment, authenticated with the data
+	salt    []byte       // key-generation salt
+	nonce   []byte       // AEAD nonce
+	data    []byte       // encrypted data packet
 }
 
-// New creates a new empty *File.
-func New() *File { return new(File) }
+// New creates a new empty *File with the given options.
+func New(opts ...Option) *File {
+	f := new(File)
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
 
-// Parse parses a binary keyfile packet into a *File.
+// NewWithKDF creates a new empty *File that derives its encryption key with
+// the specified KDF when a secret is stored by Set. It panics if k is not a
+// known KDF. It is equivalent to New(WithKDF(k)).
+func NewWithKDF(k KDF) *File { return New(WithKDF(k)) }
+
+// Parse parses a binary keyfile packet into a *File. The result does not
+// retain data, which the caller may modify or discard after Parse returns.
 func Parse(data []byte) (*File, error) {
-	if !bytes.HasPrefix(data, []byte(magic)) {
+	if len(data) <= len(magic) || !bytes.HasPrefix(data, []byte(magic)) {
 		return nil, fmt.Errorf("%w: invalid magic", ErrBadPacket)
 	}
-	data = data[len(magic):]
-	if len(data) < 2 { // slen, nlen
+	version := data[len(magic)]
+	var hlen int // slen, nlen, and KDF selector and parameters if present
+	switch version {
+	case legacyVersion:
+		hlen = 2
+	case currentVersion:
+		hlen = 6
+	default:
+		return nil, fmt.Errorf("%w: unknown version %d", ErrBadPacket, version)
+	}
+	data = data[len(magic)+1:]
+	if len(data) < hlen {
 		return nil, fmt.Errorf("%w: truncated packet", ErrBadPacket)
 	}
+	var comment string
+	if version == currentVersion && data[2]&commentFlag != 0 {
+		if len(data) == hlen || data[hlen] == 0 || hlen+1+int(data[hlen]) > len(data) {
+			return nil, fmt.Errorf("%w: invalid comment", ErrBadPacket)
+		}
+		comment = string(data[hlen+1 : hlen+1+int(data[hlen])])
+		hlen += 1 + len(comment)
+	}
 	slen := int(data[0])
-	if 2+slen > len(data) {
+	if hlen+slen > len(data) {
 		return nil, fmt.Errorf("%w: invalid salt", ErrBadPacket)
 	}
 	nlen := int(data[1])
-	if 2+nlen+nlen > len(data) {
+	if hlen+slen+nlen > len(data) {
 		return nil, fmt.Errorf("%w: invalid nonce", ErrBadPacket)
 	}
-	user := data[2+slen+nlen:]
+	kp, c, err := legacyParams, AES256GCM, error(nil)
+	if version == currentVersion {
+		kp, err = decodeParams(KDF(data[2]&kdfMask), [3]byte(data[3:6]))
+		if c = Cipher(data[2] >> 4); err == nil {
+			err = c.check()
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadPacket, err)
+	}
+
+	// Copy the fields out of the input, so that the caller's buffer (which
+	// may be much larger than the packet) is not retained by the result.
+	// A single buffer holds all three fields.
+	buf := bytes.Clone(data[hlen:])
 	return &File{
-		salt:  data[2 : 2+slen],
-		nonce: data[2+slen : 2+slen+nlen],
-		data:  user,
+		version: version,
+		params:  kp,
+		cipher:  c,
+		comment: comment,
+		salt:    buf[:slen:slen],
+		nonce:   buf[slen : slen+nlen : slen+nlen],
+		data:    buf[slen+nlen:],
 	}, nil
 }
 
 // Encode encodes f in binary format for storage, such that
-// keyfile.Parse(f.Encode()) is equivalent to f.
+// keyfile.Parse(f.Encode()) is equivalent to f. Conversely, for any input
+// accepted by Parse, Encode reproduces that input exactly.
 func (f *File) Encode() []byte {
-	slen, nlen := len(f.salt), len(f.nonce)
-	buf := make([]byte, len(magic)+2+slen+nlen+len(f.data))
-	n := copy(buf, []byte(magic))
-	buf[n] = byte(slen)
-	buf[n+1] = byte(nlen)
-	copy(buf[n+2:], f.salt)
-	copy(buf[n+2+slen:], f.nonce)
-	copy(buf[n+2+slen+nlen:], f.data)
+	buf := f.appendHeader(make([]byte, 0, maxHeaderBytes+len(f.comment)+len(f.salt)+len(f.nonce)+len(f.data)))
+	buf = append(buf, f.salt...)
+	buf = append(buf, f.nonce...)
+	return append(buf, f.data...)
+}
+
+// WriteTo writes the binary encoding of f to w, and reports the number of
+// bytes written. It writes the same bytes as Encode, but does not copy the
+// encrypted data into a separate buffer. It implements io.WriterTo.
+func (f *File) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for _, b := range [][]byte{f.appendHeader(nil), f.salt, f.nonce, f.data} {
+		n, err := w.Write(b)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// ReadFrom reads a binary keyfile packet from r until EOF, and replaces the
+// contents of f with the result. It reports ErrBadPacket if the input is not
+// a valid packet, as Parse does, and ErrFileTooLarge if r produces more than
+// DefaultMaxFileSize bytes. On error, f is not modified. It implements
+// io.ReaderFrom.
+func (f *File) ReadFrom(r io.Reader) (int64, error) {
+	data, err := io.ReadAll(io.LimitReader(r, DefaultMaxFileSize+1))
+	nr := int64(len(data))
+	if err != nil {
+		return nr, err
+	} else if nr > DefaultMaxFileSize {
+		return nr, fmt.Errorf("%w: input exceeds %d bytes", ErrFileTooLarge, DefaultMaxFileSize)
+	}
+	pf, err := Parse(data)
+	if err != nil {
+		return nr, err
+	}
+	*f = *pf
+	return nr, nil
+}
+
+// appendHeader appends the header of the binary encoding of f to buf, up to
+// but not including the salt, and returns the updated slice.
+func (f *File) appendHeader(buf []byte) []byte {
+	version, kp := f.format()
+	buf = append(buf, magic...)
+	buf = append(buf, version, byte(len(f.salt)), byte(len(f.nonce)))
+	if version != currentVersion {
+		return buf
+	}
+	sel := byte(f.cipher)<<4 | byte(kp.kdf)
+	if f.comment != "" {
+		sel |= commentFlag
+	}
+	p := kp.encode()
+	buf = append(buf, sel)
+	buf = append(buf, p[:]...)
+	if f.comment != "" {
+		buf = append(buf, byte(len(f.comment)))
+		buf = append(buf, f.comment...)
+	}
 	return buf
 }
 
+// format returns the packet version and KDF parameters of f. An empty File
+// has the current version and the default parameters for its KDF.
+func (f *File) format() (byte, kdfParams) {
+	if f.version == 0 {
+		kp, _ := f.newParams() // errors are reported by Set
+		return currentVersion, kp
+	}
+	return f.version, f.params
+}
+
+// newParams returns the KDF parameters for a new packet stored in f. If f
+// already contains a packet whose parameters are not weaker than the defaults
+// for its KDF, those are kept; otherwise the defaults are used. Any scrypt
+// settings given to New are then applied. It reports an error if the result
+// is not valid.
+func (f *File) newParams() (kdfParams, error) {
+	kp, err := defaultParams(f.params.kdf)
+	if err != nil {
+		return kp, err
+	}
+	if f.version != 0 && !f.params.weak() {
+		kp = f.params // never lower the cost of an existing packet
+	}
+	if kp.kdf != Scrypt {
+		return kp, kp.check()
+	}
+	if f.cost.logN != 0 {
+		kp.scrypt.logN = f.cost.logN
+	}
+	if f.cost.r != 0 {
+		kp.scrypt.r = f.cost.r
+	}
+	if f.cost.p != 0 {
+		kp.scrypt.p = f.cost.p
+	}
+	return kp, kp.check()
+}
+
+// IsEmpty reports whether f does not contain a key.
+func (f *File) IsEmpty() bool { return len(f.salt) == 0 || len(f.nonce) == 0 }
+
+// Salt returns a copy of the key-generation salt stored in f, or nil if f is
+// empty.
+func (f *File) Salt() []byte { return bytes.Clone(f.salt) }
+
+// Nonce returns a copy of the AEAD nonce stored in f, or nil if f is empty.
+func (f *File) Nonce() []byte { return bytes.Clone(f.nonce) }
+
+// KDF reports which key derivation function f uses.
+func (f *File) KDF() KDF { return f.params.kdf }
+
+// Cipher reports which AEAD construction f uses.
+func (f *File) Cipher() Cipher { return f.cipher }
+
+// Comment returns the plaintext comment stored in f, or "" if there is none.
+// The comment can be read without a passphrase.
+func (f *File) Comment() string { return f.comment }
+
+// SetComment sets the plaintext comment stored with the secret in f. The
+// comment is not encrypted, but it is authenticated along with the secret, so
+// a packet whose comment was modified cannot be decrypted. For the same
+// reason, the comment must be set before the secret is stored by Set (or one
+// of its variants): changing the comment of a File that already contains a
+// key makes the key undecryptable until a secret is stored again. The comment
+// is only stored in the current packet format.
+//
+// SetComment panics if comment is longer than MaxCommentBytes.
+func (f *File) SetComment(comment string) {
+	if len(comment) > MaxCommentBytes {
+		panic(fmt.Sprintf("comment is %d bytes, limit is %d", len(comment), MaxCommentBytes))
+	}
+	f.comment = comment
+}
+
+// authData returns the additional data authenticated with the data packet of
+// f: the comment, if there is one, followed by aad.
+func (f *File) authData(aad []byte) []byte {
+	if f.comment == "" {
+		return aad // compatible with packets that have no comment
+	}
+	buf := append([]byte("keyfile comment\x00"), byte(len(f.comment)))
+	buf = append(buf, f.comment...)
+	return append(buf, aad...)
+}
+
+// ScryptParams returns the scrypt cost parameters used to derive the
+// encryption key for f from its passphrase. If f does not use scrypt, it
+// returns zeroes.
+func (f *File) ScryptParams() (N, r, p int) {
+	_, kp := f.format()
+	if kp.kdf != Scrypt {
+		return 0, 0, 0
+	}
+	return 1 << kp.scrypt.logN, int(kp.scrypt.r), int(kp.scrypt.p)
+}
+
+// Argon2Params returns the Argon2id cost parameters used to derive the
+// encryption key for f from its passphrase. If f does not use Argon2id, it
+// returns zeroes.
+func (f *File) Argon2Params() (time, memoryKiB uint32, threads uint8) {
+	_, kp := f.format()
+	if kp.kdf != Argon2id {
+		return 0, 0, 0
+	}
+	return uint32(kp.argon.time), 1 << kp.argon.logMem, kp.argon.threads
+}
+
+// A Header describes the unencrypted metadata of a keyfile packet.
+type Header struct {
+	Version  int    // the packet format version
+	KDF      KDF    // the key derivation function
+	Cipher   Cipher // the AEAD construction
+	SaltLen  int    // the length of the salt in bytes
+	NonceLen int    // the length of the nonce in bytes
+	DataLen  int    // the length of the ciphertext in bytes, including the tag
+	Comment  string // the plaintext comment, if any
+
+	// Params are the KDF cost parameters: N, r, and p for scrypt; or time,
+	// memory in KiB, and threads for Argon2id.
+	Params [3]int
+
+	// Weak is true if Params are weaker than the defaults for the KDF. When
+	// a new secret is stored, weak parameters are replaced by the defaults.
+	Weak bool
+}
+
+// Header returns the metadata of f as it would be encoded. It does not
+// require a passphrase, and does not attempt decryption.
+func (f *File) Header() Header {
+	version, kp := f.format()
+	h := Header{
+		Version:  int(version),
+		KDF:      kp.kdf,
+		Cipher:   f.cipher,
+		SaltLen:  len(f.salt),
+		NonceLen: len(f.nonce),
+		DataLen:  len(f.data),
+		Weak:     kp.weak(),
+	}
+	if version == currentVersion {
+		h.Comment = f.comment
+	}
+	if kp.kdf == Argon2id {
+		h.Params = [3]int{int(kp.argon.time), 1 << kp.argon.logMem, int(kp.argon.threads)}
+	} else {
+		h.Params = [3]int{1 << kp.scrypt.logN, int(kp.scrypt.r), int(kp.scrypt.p)}
+	}
+	return h
+}
+
+// Inspect parses a binary keyfile packet and returns its metadata. It reports
+// ErrBadPacket if data is not a valid packet, as Parse does.
+func Inspect(data []byte) (Header, error) {
+	f, err := Parse(data)
+	if err != nil {
+		return Header{}, err
+	}
+	return f.Header(), nil
+}
+
 // Get decrypts and returns the key from f using the given passphrase.
 // It returns ErrBadPassphrase if the key cannot be decrypted.
 // It returns ErrNoKey if f is empty.
-func (f *File) Get(passphrase string) ([]byte, error) {
-	if len(f.salt) == 0 || len(f.nonce) == 0 {
+func (f *File) Get(passphrase string) ([]byte, error) { return f.GetAAD(passphrase, nil) }
+
+// GetAAD is as Get, but authenticates the additional data aad, which must
+// match the value given to SetAAD. It reports ErrBadPassphrase if aad does
+// not match, since a wrong passphrase and wrong additional data cannot be
+// distinguished. Get is equivalent to GetAAD with empty aad.
+func (f *File) GetAAD(passphrase string, aad []byte) ([]byte, error) {
+	return f.open(passphrase, aad, nil)
+}
+
+// GetInto decrypts the key from f using the given passphrase into dst, and
+// returns the length of the key. It reports ErrSecretWrongSize if dst is too
+// short to hold the key. Unlike Get, GetInto does not allocate memory for the
+// key, so the caller can control where it is stored and clear it after use.
+func (f *File) GetInto(dst []byte, passphrase string) (int, error) {
+	key, err := f.open(passphrase, nil, dst)
+	return len(key), err
+}
+
+// Verify reports whether passphrase decrypts the key in f, without returning
+// the key. It returns nil if so, ErrBadPassphrase if not, and ErrNoKey if f is
+// empty. The decrypted key is cleared before Verify returns.
+//
+// Verify performs the same key derivation and authenticated decryption as
+// Get, so its cost does not depend on how the passphrase differs from the
+// correct one. Whether f is empty is not secret, and is checked first.
+func (f *File) Verify(passphrase string) error {
+	key, err := f.open(passphrase, nil, nil)
+	clear(key)
+	return err
+}
+
+// open decrypts the key from f using the given passphrase and additional data.
+// If dst != nil, the key is written into dst, which must be long enough to
+// hold it; otherwise a new slice is allocated.
+func (f *File) open(passphrase string, aad, dst []byte) ([]byte, error) {
+	if f.IsEmpty() {
 		return nil, ErrNoKey
 	}
 
 	// Decrypt the key wrapper.
-	aead, err := f.keyCipher(passphrase)
+	_, kp := f.format()
+	aead, err := keyCipher(passphrase, f.salt, kp, f.cipher)
 	if err != nil {
 		return nil, fmt.Errorf("keyfile init: %w", err)
 	}
-	dec, err := aead.Open(nil, f.nonce, f.data, nil)
+	return f.openWith(aead, aad, dst)
+}
+
+// openWith is as open, but decrypts with an existing AEAD for the key
+// derived from the passphrase.
+func (f *File) openWith(aead cipher.AEAD, aad, dst []byte) ([]byte, error) {
+	if dst != nil {
+		if n := len(f.data) - aead.Overhead(); n > len(dst) {
+			return nil, fmt.Errorf("%w: key is %d bytes, buffer holds %d", ErrSecretWrongSize, n, len(dst))
+		}
+		dst = dst[:0]
+	}
+	dec, err := aead.Open(dst, f.nonce, f.data, f.authData(aad))
 	if err != nil {
-		return nil, fmt.Errorf("keyfile verify: %w", err)
+		return nil, fmt.Errorf("keyfile verify: %w", ErrBadPassphrase)
 	}
 	return dec, nil
 }
 
+// Get16 decrypts the key from f using the given passphrase and returns it as
+// a 16-byte array. It returns ErrSecretWrongSize if the stored secret is not
+// exactly 16 bytes long.
+func (f *File) Get16(passphrase string) (key [16]byte, err error) {
+	err = f.getFixed(passphrase, key[:])
+	return
+}
+
+// Get32 decrypts the key from f using the given passphrase and returns it as
+// a 32-byte array. It returns ErrSecretWrongSize if the stored secret is not
+// exactly 32 bytes long.
+func (f *File) Get32(passphrase string) (key [32]byte, err error) {
+	err = f.getFixed(passphrase, key[:])
+	return
+}
+
+// getFixed decrypts the key from f and copies it into out, which must have
+// exactly the length of the stored secret.
+func (f *File) getFixed(passphrase string, out []byte) error {
+	n, err := f.GetInto(out, passphrase)
+	if err != nil {
+		return err
+	} else if n != len(out) {
+		clear(out)
+		return fmt.Errorf("%w: key is %d bytes, want %d", ErrSecretWrongSize, n, len(out))
+	}
+	return nil
+}
+
+// Derive decrypts the key from f using the given passphrase and uses it as
+// the input keying material for HKDF-SHA256 with the given info label,
+// returning a subkey of n bytes. The same inputs always produce the same
+// subkey, and different info labels produce independent subkeys. The stored
+// key itself is not returned. It is an error if n <= 0 or n > 255*32.
+func (f *File) Derive(passphrase string, info []byte, n int) ([]byte, error) {
+	if n <= 0 || n > 255*sha256.Size {
+		return nil, fmt.Errorf("invalid subkey size %d (must be 1 to %d)", n, 255*sha256.Size)
+	}
+	key, err := f.Get(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(key)
+	sub := make([]byte, n)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, info), sub); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
 // Random generates a random secret with the given length, encrypts it with the
 // passphrase, and stores it in f, replacing any previous data. The generated
 // secret is returned. It is an error if nbytes <= 0.
@@ -138,56 +574,201 @@ func (f *File) Random(passphrase string, nbytes int) ([]byte, error) {
 }
 
 // Set encrypts the secret with the passphrase and stores it in f, replacing
-// any previous data.
+// any previous data. The encryption key is derived with the KDF already
+// selected for f (see NewWithKDF). If f already contains a key, its KDF
+// parameters are kept, unless they are weaker than the defaults; otherwise
+// the default parameters are used. In either case, any scrypt settings given
+// to New (see WithScryptN) take precedence. Set reports an error if those
+// settings are invalid, for example if they would require too much memory.
+// If Set fails, f is not modified.
 func (f *File) Set(passphrase string, secret []byte) error {
-	*f = File{} // reset
-	aead, err := f.keyCipher(passphrase)
+	return f.SetSaltSize(passphrase, secret, DefaultSaltBytes)
+}
+
+// SetSaltSize is as Set, but generates a key-generation salt of saltBytes
+// bytes rather than DefaultSaltBytes. It is an error if saltBytes is not
+// between MinSaltBytes and MaxSaltBytes inclusive.
+func (f *File) SetSaltSize(passphrase string, secret []byte, saltBytes int) error {
+	return f.seal(passphrase, secret, nil, saltBytes)
+}
+
+// SetAAD is as Set, but also authenticates the additional data aad, which
+// binds the secret to a context such as a hostname or tenant ID. The same aad
+// must be given to GetAAD to decrypt the secret. The aad itself is not stored
+// in f.
+func (f *File) SetAAD(passphrase string, secret, aad []byte) error {
+	return f.seal(passphrase, secret, aad, DefaultSaltBytes)
+}
+
+// seal encrypts the secret and additional data with the passphrase using a
+// fresh salt of saltBytes bytes, and stores it in f.
+func (f *File) seal(passphrase string, secret, aad []byte, saltBytes int) error {
+	if saltBytes < MinSaltBytes || saltBytes > MaxSaltBytes {
+		return fmt.Errorf("invalid salt size %d (must be %d to %d bytes)", saltBytes, MinSaltBytes, MaxSaltBytes)
+	}
+	kp, err := f.newParams()
+	if err != nil {
+		return err
+	}
+	salt, err := newSalt(saltBytes)
+	if err != nil {
+		return fmt.Errorf("key salt: %w", err)
+	}
+	aead, err := keyCipher(passphrase, salt, kp, f.cipher)
 	if err != nil {
 		return fmt.Errorf("keyfile init: %w", err)
 	}
-	f.nonce = make([]byte, aead.NonceSize())
-	if _, err := crand.Read(f.nonce); err != nil {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := crand.Read(nonce); err != nil {
 		return err
 	}
-	f.data = aead.Seal(nil, f.nonce, secret, nil)
+	*f = File{
+		version: currentVersion,
+		params:  kp,
+		cipher:  f.cipher,
+		cost:    f.cost,
+		comment: f.comment,
+		salt:    salt,
+		nonce:   nonce,
+		data:    aead.Seal(nil, nonce, secret, f.authData(aad)),
+	}
 	return nil
 }
 
-// keySalt returns the passphrase key salt, creating it if necessary.  This can
-// only fail if random generation fails.
-func (f *File) keySalt() ([]byte, error) {
-	if len(f.salt) == 0 {
-		var buf [keySaltBytes]byte
-		if _, err := crand.Read(buf[:]); err != nil {
-			return nil, err
-		}
-		f.salt = buf[:]
+// Rekey decrypts the secret in f with oldPass and re-encrypts it with newPass,
+// using a fresh salt and nonce. The new salt has the same length as the old
+// one, or DefaultSaltBytes if the old length is out of range. The KDF, cipher,
+// comment, and KDF parameters are kept, except that parameters weaker than
+// the defaults are raised to the defaults, as for Set. It reports
+// ErrBadPassphrase if oldPass does not decrypt f. If Rekey fails, f is not
+// modified.
+//
+// Rekey does not support secrets stored with additional data (see SetAAD);
+// use GetAAD and SetAAD to rekey those.
+func (f *File) Rekey(oldPass, newPass string) error {
+	secret, err := f.Get(oldPass)
+	if err != nil {
+		return err
+	}
+	defer clear(secret)
+	saltBytes := len(f.salt)
+	if saltBytes < MinSaltBytes || saltBytes > MaxSaltBytes {
+		saltBytes = DefaultSaltBytes
 	}
-	return f.salt, nil
+	return f.seal(newPass, secret, nil, saltBytes)
 }
 
-// keyCipher returns a cipher.AEAD for f using the given passphrase.
-func (f *File) keyCipher(passphrase string) (cipher.AEAD, error) {
-	salt, err := f.keySalt()
+// SetDeterministic encrypts the secret with the passphrase and stores it in f,
+// replacing any previous data, using the given salt and a nonce derived from
+// the secret instead of random values. The resulting packet is the same every
+// time for the same inputs. The salt must be 1 to 255 bytes long.
+//
+// WARNING: This is meant for test fixtures and other reproducible setups. It
+// must not be used to protect real secrets. A fixed salt allows an attacker to
+// precompute guesses for the passphrase, and the encoding reveals whether two
+// files with the same passphrase and salt hold the same secret.
+func (f *File) SetDeterministic(passphrase string, secret, salt []byte) error {
+	if len(salt) == 0 || len(salt) > 255 {
+		return errors.New("invalid salt size (must be 1 to 255 bytes)")
+	}
+	kp, err := f.newParams()
 	if err != nil {
-		return nil, fmt.Errorf("key salt: %w", err)
+		return err
 	}
-	ckey, err := scrypt.Key([]byte(passphrase), salt, scryptWorkFactor, 8, 1, aesKeyBytes)
+	salt = bytes.Clone(salt)
+	ckey, err := kp.deriveKey(passphrase, salt)
 	if err != nil {
-		return nil, fmt.Errorf("scrypt: %w", err)
+		return fmt.Errorf("keyfile init: %w", err)
 	}
-	blk, err := aes.NewCipher(ckey)
+	defer clear(ckey)
+	aead, err := newAEAD(f.cipher, ckey)
+	if err != nil {
+		return fmt.Errorf("keyfile init: %w", err)
+	}
+
+	// Derive the nonce from the secret and the additional data under the
+	// encryption key, so that the same key and nonce are never used for two
+	// different inputs to the AEAD. The additional data are self-delimiting,
+	// and are marked by a distinct label so that packets without a comment
+	// keep the nonce they had before comments were supported.
+	h := hmac.New(sha256.New, ckey)
+	if ad := f.authData(nil); len(ad) == 0 {
+		h.Write([]byte("keyfile nonce\x00"))
+	} else {
+		h.Write([]byte("keyfile nonce\x01"))
+		h.Write(ad)
+	}
+	h.Write(secret)
+	nonce := h.Sum(nil)[:aead.NonceSize()]
+	*f = File{
+		version: currentVersion,
+		params:  kp,
+		cipher:  f.cipher,
+		cost:    f.cost,
+		comment: f.comment,
+		salt:    salt,
+		nonce:   nonce,
+		data:    aead.Seal(nil, nonce, secret, f.authData(nil)),
+	}
+	return nil
+}
+
+// newSalt returns a fresh random key-generation salt of n bytes. This can only
+// fail if random generation fails.
+func newSalt(n int) ([]byte, error) {
+	salt := make([]byte, n)
+	if _, err := crand.Read(salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+// keyCipher returns a cipher.AEAD of type c using a key derived from the
+// given passphrase and salt with the parameters kp.
+func keyCipher(passphrase string, salt []byte, kp kdfParams, c Cipher) (cipher.AEAD, error) {
+	ckey, err := kp.deriveKey(passphrase, salt)
 	if err != nil {
 		return nil, err
 	}
-	return cipher.NewGCM(blk)
+	defer clear(ckey) // the cipher keeps its own copy of the key schedule
+	return newAEAD(c, ckey)
 }
 
+// ReadFile reads and parses the keyfile at path, without decrypting it. Like
+// LoadKey, it reports ErrNotRegularFile without reading if path is not a
+// regular file, and ErrFileTooLarge if the file is longer than
+// DefaultMaxFileSize.
+func ReadFile(path string) (*File, error) { return readKeyFile(path, DefaultMaxFileSize) }
+
 // LoadKey is a convenience function to load and decrypt the contents of a key
 // from a stored binary-format keyfile. The pf function is called to obtain a
-// passphrase.
+// passphrase. It is equivalent to LoadKeyLimit with DefaultMaxFileSize.
 func LoadKey(path string, pf func() (string, error)) ([]byte, error) {
-	data, err := os.ReadFile(path)
+	return LoadKeyLimit(path, DefaultMaxFileSize, pf)
+}
+
+// LoadKeyLimit is as LoadKey, but reports ErrFileTooLarge without calling pf
+// if the file at path is longer than maxBytes. If maxBytes <= 0, it uses
+// DefaultMaxFileSize.
+func LoadKeyLimit(path string, maxBytes int64, pf func() (string, error)) ([]byte, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxFileSize
+	}
+	kf, err := readKeyFile(path, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	passphrase, err := pf()
+	if err != nil {
+		return nil, err
+	}
+	return kf.Get(passphrase)
+}
+
+// LoadKeyFS is as LoadKey, but reads the keyfile at path from fsys. The path
+// must satisfy fs.ValidPath.
+func LoadKeyFS(fsys fs.FS, path string, pf func() (string, error)) ([]byte, error) {
+	data, err := readFSLimit(fsys, path, DefaultMaxFileSize)
 	if err != nil {
 		return nil, err
 	}
@@ -201,3 +782,355 @@ func LoadKey(path string, pf func() (string, error)) ([]byte, error) {
 	}
 	return kf.Get(passphrase)
 }
+
+// A LoadResult describes a key loaded by LoadKeyResult.
+type LoadResult struct {
+	Key        []byte        // the decrypted key
+	Version    int           // the packet format version
+	KDF        KDF           // the key derivation function
+	Cipher     Cipher        // the AEAD construction
+	Derivation time.Duration // time spent deriving the key and decrypting
+
+	// Legacy is true if the packet uses an older format than Set writes.
+	Legacy bool
+
+	// Weak is true if the packet uses weaker parameters than Set would, for
+	// example a shorter salt.
+	Weak bool
+}
+
+// LoadKeyResult is as LoadKey, but reports details about the stored packet
+// and the cost of loading it along with the key.
+func LoadKeyResult(path string, pf func() (string, error)) (*LoadResult, error) {
+	kf, err := readKeyFile(path, DefaultMaxFileSize)
+	if err != nil {
+		return nil, err
+	}
+	passphrase, err := pf()
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	key, err := kf.Get(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	version, kp := kf.format()
+	return &LoadResult{
+		Key:        key,
+		Version:    int(version),
+		KDF:        kp.kdf,
+		Cipher:     kf.cipher,
+		Derivation: time.Since(start),
+		Legacy:     version < currentVersion,
+		Weak:       len(kf.salt) < DefaultSaltBytes || kp.weak(),
+	}, nil
+}
+
+// SaveKey is a convenience function to encrypt secret and store it as a
+// binary-format keyfile at path, replacing any existing file. The pf function
+// is called to obtain a passphrase. It is equivalent to SaveKeyOptions with
+// default options.
+func SaveKey(path string, secret []byte, pf func() (string, error)) error {
+	return SaveKeyOptions(path, secret, pf, nil)
+}
+
+// SaveKeyIfAbsent is as SaveKey, but stores the key only if path does not
+// already exist. It is equivalent to SaveKeyOptions with NoOverwrite set.
+func SaveKeyIfAbsent(path string, secret []byte, pf func() (string, error)) error {
+	return SaveKeyOptions(path, secret, pf, &SaveOptions{NoOverwrite: true})
+}
+
+// SaveOptions are optional settings for SaveKeyOptions. A nil *SaveOptions is
+// ready for use and provides default values.
+type SaveOptions struct {
+	// Mode is the permission mode of the file. If zero, 0600 is used.
+	Mode fs.FileMode
+
+	// If NoOverwrite is true and path already exists, SaveKeyOptions reports
+	// ErrExists without calling pf, instead of replacing the file.
+	//
+	// The file is written and synced under a temporary name, then hard-linked
+	// into place, so a reader never sees a partial file. On a filesystem that
+	// does not support hard links, the file is instead created in place with
+	// O_EXCL, so a crash while writing may leave a partial file at path.
+	NoOverwrite bool
+}
+
+func (o *SaveOptions) mode() fs.FileMode {
+	if o == nil || o.Mode == 0 {
+		return 0600
+	}
+	return o.Mode.Perm()
+}
+
+func (o *SaveOptions) noOverwrite() bool { return o != nil && o.NoOverwrite }
+
+// SaveKeyOptions encrypts secret and stores it as a binary-format keyfile at
+// path, using the passphrase returned by pf. Passing nil opts is equivalent
+// to a zero SaveOptions.
+//
+// The file is written atomically: readers never observe a partial packet. By
+// default, it replaces any existing file at path. If opts.NoOverwrite is set,
+// the packet is instead written and synced to a temporary file in the same
+// directory, which is then hard-linked to path. The link fails if path
+// exists, so concurrent callers cannot both succeed.
+func SaveKeyOptions(path string, secret []byte, pf func() (string, error), opts *SaveOptions) error {
+	// Check before prompting, so the caller is not asked for a passphrase that
+	// will not be used. The exclusive create below is what guarantees safety.
+	if opts.noOverwrite() {
+		if _, err := os.Lstat(path); err == nil {
+			return fmt.Errorf("%w: %q", ErrExists, path)
+		}
+	}
+	passphrase, err := pf()
+	if err != nil {
+		return err
+	}
+	var kf File
+	if err := kf.Set(passphrase, secret); err != nil {
+		return err
+	}
+	if !opts.noOverwrite() {
+		return atomicfile.WriteData(path, kf.Encode(), opts.mode())
+	}
+	return linkNew(path, kf.Encode(), opts.mode())
+}
+
+// linkNew atomically creates a file at path with the given contents and mode,
+// or reports ErrExists if path already exists. If the filesystem does not
+// support hard links, it falls back to createNew.
+func linkNew(path string, data []byte, mode fs.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, werr := f.Write(data)
+	if err := errors.Join(werr, f.Chmod(mode), f.Sync(), f.Close()); err != nil {
+		return err
+	}
+	if err := os.Link(f.Name(), path); errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("%w: %q", ErrExists, path)
+	} else if linkUnsupported(err) {
+		return createNew(path, data, mode)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// linkUnsupported reports whether err indicates that the filesystem cannot
+// create a hard link. Filesystems without hard links report either that the
+// operation is unsupported, or (as vfat does on Linux) that it is not
+// permitted. The temporary file is in the same directory as the target, so a
+// cross-device error is not expected.
+func linkUnsupported(err error) bool {
+	return errors.Is(err, errors.ErrUnsupported) || errors.Is(err, fs.ErrPermission)
+}
+
+// createNew creates a file at path with the given contents and mode using an
+// exclusive create, or reports ErrExists if path already exists. Unlike
+// linkNew, the file is written in place; if writing fails, it is removed.
+func createNew(path string, data []byte, mode fs.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("%w: %q", ErrExists, path)
+	} else if err != nil {
+		return err
+	}
+	_, werr := f.Write(data)
+	if err := errors.Join(werr, f.Chmod(mode), f.Sync(), f.Close()); err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+// LoadAll loads and decrypts each of the keyfiles in dir. The pf function is
+// called with the path of each file to obtain its passphrase. Only entries
+// directly within dir are considered; subdirectories are not searched.
+// Symbolic links are followed, and a link that does not resolve to a regular
+// file is reported as an error for that path.
+//
+// LoadAll returns a map from path to key for each file that was successfully
+// loaded, and a map from path to error for each file that was not. A failure
+// to load one file does not prevent the others from loading. If dir itself
+// cannot be read, the error is reported under the path dir.
+func LoadAll(dir string, pf func(path string) (string, error)) (map[string][]byte, map[string]error) {
+	keys := make(map[string][]byte)
+	errs := make(map[string]error)
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		errs[dir] = err
+		return keys, errs
+	}
+	for _, de := range des {
+		if de.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, de.Name())
+		key, err := LoadKey(path, func() (string, error) { return pf(path) })
+		if err != nil {
+			errs[path] = err
+		} else {
+			keys[path] = key
+		}
+	}
+	return keys, errs
+}
+
+// LoadKeys loads and decrypts each of the keyfiles named by paths, using a
+// single passphrase. The pf function is called at most once, and only if at
+// least one of the files is a valid keyfile. Files that share a salt and KDF
+// parameters derive their encryption key only once.
+//
+// LoadKeys returns a map from path to key for each file that was successfully
+// loaded. If any file could not be loaded, it also returns a LoadError that
+// maps each such path to its error; a failure to load one file does not
+// prevent the others from loading. If pf reports an error, LoadKeys returns
+// nil and that error.
+func LoadKeys(paths []string, pf func() (string, error)) (map[string][]byte, error) {
+	files := make(map[string]*File)
+	lerr := make(LoadError)
+	for _, path := range paths {
+		kf, err := readKeyFile(path, DefaultMaxFileSize)
+		if err != nil {
+			lerr[path] = err
+		} else {
+			files[path] = kf
+		}
+	}
+	keys := make(map[string][]byte)
+	if len(files) != 0 {
+		passphrase, err := pf()
+		if err != nil {
+			return nil, err
+		}
+		type cacheKey struct {
+			kp   kdfParams
+			c    Cipher
+			salt string
+		}
+		cache := make(map[cacheKey]cipher.AEAD)
+		for path, kf := range files {
+			if kf.IsEmpty() {
+				lerr[path] = ErrNoKey
+				continue
+			}
+			_, kp := kf.format()
+			ck := cacheKey{kp: kp, c: kf.cipher, salt: string(kf.salt)}
+			aead, ok := cache[ck]
+			if !ok {
+				aead, err = keyCipher(passphrase, kf.salt, kp, kf.cipher)
+				if err != nil {
+					lerr[path] = fmt.Errorf("keyfile init: %w", err)
+					continue
+				}
+				cache[ck] = aead
+			}
+			key, err := kf.openWith(aead, nil, nil)
+			if err != nil {
+				lerr[path] = err
+			} else {
+				keys[path] = key
+			}
+		}
+	}
+	if len(lerr) != 0 {
+		return keys, lerr
+	}
+	return keys, nil
+}
+
+// A LoadError is reported by LoadKeys when one or more files could not be
+// loaded. It maps the path of each such file to its error.
+type LoadError map[string]error
+
+// Error satisfies the error interface.
+func (e LoadError) Error() string {
+	paths := e.paths()
+	if len(paths) == 1 {
+		return fmt.Sprintf("load %s: %v", paths[0], e[paths[0]])
+	}
+	msgs := make([]string, len(paths))
+	for i, path := range paths {
+		msgs[i] = fmt.Sprintf("%s: %v", path, e[path])
+	}
+	return fmt.Sprintf("load failed for %d files: %s", len(paths), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the errors for each path in e, ordered by path. This allows
+// errors.Is and errors.As to match any of them.
+func (e LoadError) Unwrap() []error {
+	paths := e.paths()
+	errs := make([]error, len(paths))
+	for i, path := range paths {
+		errs[i] = e[path]
+	}
+	return errs
+}
+
+func (e LoadError) paths() []string {
+	paths := make([]string, 0, len(e))
+	for path := range e {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// readKeyFile reads and parses the keyfile at path, subject to the same limits
+// as readFileLimit.
+func readKeyFile(path string, maxBytes int64) (*File, error) {
+	data, err := readFileLimit(path, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// readFileLimit reads the contents of the regular file at path, or reports
+// ErrFileTooLarge if it is longer than maxBytes. It reports ErrNotRegularFile
+// without reading if path is not a regular file, since opening a pipe may
+// block and reading a device may never end.
+func readFileLimit(path string, maxBytes int64) ([]byte, error) {
+	if fi, err := os.Stat(path); err != nil {
+		return nil, err
+	} else if !fi.Mode().IsRegular() {
+		return nil, fmt.Errorf("%w: %q", ErrNotRegularFile, path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readLimit(f, path, maxBytes)
+}
+
+// readFSLimit is as readFileLimit, but reads the file at path from fsys.
+func readFSLimit(fsys fs.FS, path string, maxBytes int64) ([]byte, error) {
+	if fi, err := fs.Stat(fsys, path); err != nil {
+		return nil, err
+	} else if !fi.Mode().IsRegular() {
+		return nil, fmt.Errorf("%w: %q", ErrNotRegularFile, path)
+	}
+	f, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readLimit(f, path, maxBytes)
+}
+
+// readLimit reads r to EOF, or reports ErrFileTooLarge if r produces more
+// than maxBytes. The path is used only for error messages.
+func readLimit(r io.Reader, path string, maxBytes int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxBytes+1))
+	if err != nil {
+		return nil, err
+	} else if int64(len(data)) > maxBytes {
+		return nil, fmt.Errorf("%w: %q exceeds %d bytes", ErrFileTooLarge, path, maxBytes)
+	}
+	return data, nil
+}
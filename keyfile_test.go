@@ -5,9 +5,18 @@ package keyfile_test
 import (
 	crand "crypto/rand"
 	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	mrand "math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"testing/quick"
 
 	"github.com/creachadair/keyfile"
 	"github.com/creachadair/mds/mtest"
@@ -16,6 +25,9 @@ import (
 
 func TestEmpty(t *testing.T) {
 	f := keyfile.New()
+	if !f.IsEmpty() {
+		t.Error("IsEmpty: got false for a new file, want true")
+	}
 	key, err := f.Get("password")
 	if !errors.Is(err, keyfile.ErrNoKey) {
 		t.Errorf("Get (empty): got %q, %v, want %v", string(key), err, keyfile.ErrNoKey)
@@ -23,15 +35,40 @@ func TestEmpty(t *testing.T) {
 }
 
 func TestParseErrors(t *testing.T) {
+	salt64 := strings.Repeat("s", 64)
 	for _, test := range []string{
-		"",                  // missing magic number
-		"X",                 // invalid magic number
-		"KF",                // "
-		"KF\x00",            // incorrect version
-		"KF\x01",            // "
-		"KF\x02",            // short packet
-		"KF\x02\x03\x00",    // truncated salt
-		"KF\x02\x03\x02abc", // truncated nonce
+		"",                                      // missing magic number
+		"X",                                     // invalid magic number
+		"KF",                                    // "
+		"KF\x00",                                // incorrect version
+		"KF\x01",                                // "
+		"KF\x04",                                // unknown version
+		"KF\x02",                                // short packet
+		"KF\x02\x03\x00",                        // truncated salt
+		"KF\x02\x03\x02abc",                     // truncated nonce
+		"KF\x03\x00\x00",                        // truncated selector
+		"KF\x03\x00\x00\x00\x0f\x08",            // truncated KDF parameters
+		"KF\x03\x00\x00\x00\x00\x08\x01",        // invalid log2(N)
+		"KF\x03\x00\x00\x00\x1f\x08\x01",        // "
+		"KF\x03\x00\x00\x00\x0f\x00\x01",        // invalid r
+		"KF\x03\x00\x00\x00\x0f\x08\x00",        // invalid p
+		"KF\x03\x00\x00\x00\x1e\x08\x01",        // too much memory
+		"KF\x03\x03\x02\x00\x0f\x08\x01abc",     // truncated nonce
+		"KF\x03\x00\x00\x02\x0f\x08\x01",        // unknown KDF
+		"KF\x03\x00\x00\x20\x0f\x08\x01",        // unknown cipher
+		"KF\x03\x00\x00\x01\x00\x10\x04",        // invalid Argon2id time
+		"KF\x03\x00\x00\x01\x03\x15\x04",        // Argon2id memory too large
+		"KF\x03\x00\x00\x01\x03\x04\x04",        // Argon2id memory too small for threads
+		"KF\x03\x00\x00\x08\x0f\x08\x01",        // missing comment
+		"KF\x03\x00\x00\x08\x0f\x08\x01\x00",    // empty comment
+		"KF\x03\x00\x00\x08\x0f\x08\x01\x05abc", // truncated comment
+		"KF\x03\x01\x00\x08\x0f\x08\x01\x01a",   // truncated salt after comment
+
+		// A large salt followed by a short nonce that runs past the end.
+		"KF\x02\x40\x04" + salt64 + "nn",                    // truncated nonce
+		"KF\x02\x40\x01" + salt64,                           // missing nonce
+		"KF\x03\x40\x0c\x00\x0f\x08\x01" + salt64 + "nonce", // truncated nonce
+		"KF\x03\xff\x01\x00\x0f\x08\x01" + salt64 + salt64,  // truncated salt
 	} {
 		f, err := keyfile.Parse([]byte(test))
 		if !errors.Is(err, keyfile.ErrBadPacket) {
@@ -89,7 +126,7 @@ func TestEncodeParse(t *testing.T) {
 		t.Fatalf("Parsing keyfile: %v", err)
 	}
 
-	opt := cmp.AllowUnexported(keyfile.File{})
+	opt := cmp.Exporter(func(reflect.Type) bool { return true }) // compare unexported fields
 	if diff := cmp.Diff(f, dec, opt); diff != "" {
 		t.Errorf("Keyfile mismatch (-want, +got):\n%s", diff)
 	}
@@ -101,6 +138,262 @@ func TestEncodeParse(t *testing.T) {
 	}
 }
 
+// validPacket is a quick.Generator for well-formed binary keyfile packets.
+type validPacket []byte
+
+func (validPacket) Generate(r *mrand.Rand, size int) reflect.Value {
+	field := func(max int) []byte {
+		buf := make([]byte, r.Intn(max+1))
+		r.Read(buf)
+		return buf
+	}
+	salt, nonce, data := field(255), field(255), field(4*size)
+	scryptParams := []byte{byte(1 + r.Intn(20)), byte(1 + r.Intn(8)), byte(1 + r.Intn(255))}
+	argonParams := []byte{byte(1 + r.Intn(255)), byte(6 + r.Intn(15)), byte(1 + r.Intn(8))}
+
+	var pkt []byte
+	switch r.Intn(3) {
+	case 0:
+		pkt = append([]byte("KF\x02"), byte(len(salt)), byte(len(nonce)))
+	case 1, 2:
+		sel := byte(r.Intn(2))<<4 | byte(r.Intn(2)) // cipher and KDF
+		params := scryptParams
+		if sel&1 != 0 {
+			params = argonParams
+		}
+		comment := field(255)
+		if len(comment) != 0 {
+			sel |= 0x08
+		}
+		pkt = append([]byte("KF\x03"), byte(len(salt)), byte(len(nonce)), sel)
+		pkt = append(pkt, params...)
+		if len(comment) != 0 {
+			pkt = append(pkt, byte(len(comment)))
+			pkt = append(pkt, comment...)
+		}
+	}
+	pkt = append(pkt, salt...)
+	pkt = append(pkt, nonce...)
+	pkt = append(pkt, data...)
+	return reflect.ValueOf(validPacket(pkt))
+}
+
+func TestEncodeParseProperties(t *testing.T) {
+	cfg := &quick.Config{Rand: mrand.New(mrand.NewSource(20241016093512))}
+	opt := cmp.Exporter(func(reflect.Type) bool { return true }) // compare unexported fields
+
+	// For any packet Parse accepts, Encode reproduces it exactly.
+	if err := quick.Check(func(pkt validPacket) bool {
+		f, err := keyfile.Parse(pkt)
+		if err != nil {
+			t.Logf("Parse(%q): unexpected error: %v", pkt, err)
+			return false
+		}
+		return string(f.Encode()) == string(pkt)
+	}, cfg); err != nil {
+		t.Errorf("Encode(Parse(b)) != b: %v", err)
+	}
+
+	// The same holds for arbitrary input: Parse must either reject it or
+	// accept it in a form that encodes back to the same bytes.
+	if err := quick.Check(func(v byte, tail []byte) bool {
+		pkt := append([]byte{'K', 'F', 2 + v%2}, tail...)
+		f, err := keyfile.Parse(pkt)
+		return err != nil || string(f.Encode()) == string(pkt)
+	}, cfg); err != nil {
+		t.Errorf("Encode(Parse(b)) != b for arbitrary input: %v", err)
+	}
+
+	// For any file, Parse recovers it from its encoding.
+	if err := quick.Check(func(pkt validPacket) bool {
+		f, err := keyfile.Parse(pkt)
+		if err != nil {
+			return false
+		}
+		g, err := keyfile.Parse(f.Encode())
+		if err != nil {
+			t.Logf("Parse(Encode(f)): unexpected error: %v", err)
+			return false
+		}
+		return cmp.Equal(f, g, opt)
+	}, cfg); err != nil {
+		t.Errorf("Parse(Encode(f)) != f: %v", err)
+	}
+}
+
+func TestChaCha20Poly1305(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20241019142236)))
+	const passphrase = "no aes hardware"
+	secret := []byte("stream cipher")
+
+	f := keyfile.New(keyfile.WithCipher(keyfile.ChaCha20Poly1305), keyfile.WithKDF(keyfile.Argon2id))
+	if err := f.Set(passphrase, secret); err != nil {
+		t.Fatalf("Set: unexpected error: %v", err)
+	}
+	g, err := keyfile.Parse(f.Encode())
+	if err != nil {
+		t.Fatalf("Parse: unexpected error: %v", err)
+	}
+	if c, k := g.Cipher(), g.KDF(); c != keyfile.ChaCha20Poly1305 || k != keyfile.Argon2id {
+		t.Errorf("Parsed file: got %v, %v; want %v, %v", c, k, keyfile.ChaCha20Poly1305, keyfile.Argon2id)
+	}
+	if got, err := g.Get(passphrase); err != nil {
+		t.Errorf("Get: unexpected error: %v", err)
+	} else if diff := cmp.Diff(secret, got); diff != "" {
+		t.Errorf("Wrong key value (-want, +got):\n%s", diff)
+	}
+
+	// Decrypting with the other construction must fail.
+	pkt := g.Encode()
+	pkt[5] &^= 0xf0
+	h, err := keyfile.Parse(pkt)
+	if err != nil {
+		t.Fatalf("Parse: unexpected error: %v", err)
+	}
+	if got, err := h.Get(passphrase); !errors.Is(err, keyfile.ErrBadPassphrase) {
+		t.Errorf("Get with AES-GCM: got %q, %v; want %v", got, err, keyfile.ErrBadPassphrase)
+	}
+}
+
+func TestLegacyFormat(t *testing.T) {
+	const passphrase = "old but gold"
+	secret := []byte("still readable")
+	var f keyfile.File
+	if err := f.SetDeterministic(passphrase, secret, []byte("0123456789abcdef")); err != nil {
+		t.Fatalf("SetDeterministic: unexpected error: %v", err)
+	}
+
+	// The legacy format implies scrypt with the current default parameters,
+	// so removing the selector and parameters gives a valid legacy packet for
+	// the same secret.
+	v3 := f.Encode()
+	v2 := append([]byte("KF\x02"), v3[3:5]...)
+	v2 = append(v2, v3[9:]...)
+	g, err := keyfile.Parse(v2)
+	if err != nil {
+		t.Fatalf("Parse(v2): unexpected error: %v", err)
+	}
+	if got, err := g.Get(passphrase); err != nil {
+		t.Errorf("Get(v2): unexpected error: %v", err)
+	} else if diff := cmp.Diff(secret, got); diff != "" {
+		t.Errorf("Wrong key value (-want, +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(v2, g.Encode()); diff != "" {
+		t.Errorf("Encode(v2) changed format (-want, +got):\n%s", diff)
+	}
+
+	// The stored parameters are used for decryption.
+	v3[6]-- // log2(N)
+	h, err := keyfile.Parse(v3)
+	if err != nil {
+		t.Fatalf("Parse(v3): unexpected error: %v", err)
+	}
+	if got, err := h.Get(passphrase); err == nil {
+		t.Errorf("Get with modified N: got %q, want error", got)
+	}
+}
+
+func TestArgon2id(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20241019094418)))
+	const passphrase = "compliance checklist"
+	secret := []byte("memory hard")
+
+	f := keyfile.NewWithKDF(keyfile.Argon2id)
+	if err := f.Set(passphrase, secret); err != nil {
+		t.Fatalf("Set: unexpected error: %v", err)
+	}
+	g, err := keyfile.Parse(f.Encode())
+	if err != nil {
+		t.Fatalf("Parse: unexpected error: %v", err)
+	}
+	if got := g.KDF(); got != keyfile.Argon2id {
+		t.Errorf("KDF: got %v, want %v", got, keyfile.Argon2id)
+	}
+	if time, mem, threads := g.Argon2Params(); time != 3 || mem != 64<<10 || threads != 4 {
+		t.Errorf("Argon2Params: got (%d, %d, %d), want (3, %d, 4)", time, mem, threads, 64<<10)
+	}
+	if n, r, p := g.ScryptParams(); n != 0 || r != 0 || p != 0 {
+		t.Errorf("ScryptParams: got (%d, %d, %d), want zeroes", n, r, p)
+	}
+	if got, err := g.Get(passphrase); err != nil {
+		t.Errorf("Get: unexpected error: %v", err)
+	} else if diff := cmp.Diff(secret, got); diff != "" {
+		t.Errorf("Wrong key value (-want, +got):\n%s", diff)
+	}
+
+	// Replacing the secret keeps the selected KDF.
+	if err := g.Set(passphrase, []byte("again")); err != nil {
+		t.Fatalf("Set: unexpected error: %v", err)
+	} else if got := g.KDF(); got != keyfile.Argon2id {
+		t.Errorf("KDF after Set: got %v, want %v", got, keyfile.Argon2id)
+	}
+}
+
+func TestParseCopies(t *testing.T) {
+	pkt := mustParse(t, 32).Encode()
+	want := string(pkt)
+	f, err := keyfile.Parse(pkt)
+	if err != nil {
+		t.Fatalf("Parse: unexpected error: %v", err)
+	}
+	for i := range pkt {
+		pkt[i] = 0xff // clobber the input
+	}
+	if got := string(f.Encode()); got != want {
+		t.Errorf("Encode after modifying input: got %q, want %q", got, want)
+	}
+}
+
+func TestWriteToReadFrom(t *testing.T) {
+	for _, f := range []*keyfile.File{keyfile.New(), mustParse(t, 32)} {
+		want := f.Encode()
+
+		var buf strings.Builder
+		nw, err := f.WriteTo(&buf)
+		if err != nil {
+			t.Fatalf("WriteTo: unexpected error: %v", err)
+		} else if nw != int64(len(want)) {
+			t.Errorf("WriteTo: wrote %d bytes, want %d", nw, len(want))
+		}
+		if diff := cmp.Diff(string(want), buf.String()); diff != "" {
+			t.Errorf("WriteTo output (-want, +got):\n%s", diff)
+		}
+
+		var g keyfile.File
+		nr, err := g.ReadFrom(strings.NewReader(buf.String()))
+		if err != nil {
+			t.Fatalf("ReadFrom: unexpected error: %v", err)
+		} else if nr != nw {
+			t.Errorf("ReadFrom: read %d bytes, want %d", nr, nw)
+		}
+		if diff := cmp.Diff(string(want), string(g.Encode())); diff != "" {
+			t.Errorf("ReadFrom result (-want, +got):\n%s", diff)
+		}
+	}
+
+	// A truncated packet reports ErrBadPacket and leaves the receiver alone.
+	f := mustParse(t, 16)
+	want := string(f.Encode())
+	for _, n := range []int{0, 3, 8, 12} {
+		if _, err := f.ReadFrom(strings.NewReader(want[:n])); !errors.Is(err, keyfile.ErrBadPacket) {
+			t.Errorf("ReadFrom(%q): got %v, want %v", want[:n], err, keyfile.ErrBadPacket)
+		}
+	}
+	if got := string(f.Encode()); got != want {
+		t.Errorf("After failed ReadFrom: got %q, want %q", got, want)
+	}
+
+	// An input that exceeds the size limit is rejected.
+	big := io.MultiReader(strings.NewReader(want), io.LimitReader(zeroReader{}, keyfile.DefaultMaxFileSize))
+	if _, err := f.ReadFrom(big); !errors.Is(err, keyfile.ErrFileTooLarge) {
+		t.Errorf("ReadFrom large input: got %v, want %v", err, keyfile.ErrFileTooLarge)
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(data []byte) (int, error) { clear(data); return len(data), nil }
+
 func TestSet(t *testing.T) {
 	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20240427103839)))
 	const secret = "key"
@@ -109,8 +402,11 @@ func TestSet(t *testing.T) {
 	if err := f.Set("whatever", []byte(secret)); err != nil {
 		t.Errorf("Set %q: unexpected error: %v", secret, err)
 	}
-	if got, err := f.Get("wrong"); err == nil {
-		t.Errorf("Get with wrong passphrase: got %q, %v want error", string(got), err)
+	if f.IsEmpty() {
+		t.Error("IsEmpty: got true after Set, want false")
+	}
+	if got, err := f.Get("wrong"); !errors.Is(err, keyfile.ErrBadPassphrase) {
+		t.Errorf("Get with wrong passphrase: got %q, %v want %v", string(got), err, keyfile.ErrBadPassphrase)
 	}
 	if key, err := f.Get("whatever"); err != nil {
 		t.Errorf("Get failed: %v", err)
@@ -118,3 +414,1004 @@ func TestSet(t *testing.T) {
 		t.Errorf("Get: got %q, want %q", got, secret)
 	}
 }
+
+func TestParams(t *testing.T) {
+	f := keyfile.New()
+	if salt, nonce := f.Salt(), f.Nonce(); salt != nil || nonce != nil {
+		t.Errorf("Empty file: got salt %x, nonce %x; want nil", salt, nonce)
+	}
+
+	const passphrase = "inspect me"
+	salt := []byte("0123456789abcdef")
+	if err := f.SetDeterministic(passphrase, []byte("secret"), salt); err != nil {
+		t.Fatalf("SetDeterministic: unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(salt, f.Salt()); diff != "" {
+		t.Errorf("Wrong salt (-want, +got):\n%s", diff)
+	}
+	pkt := f.Encode()
+	if diff := cmp.Diff(pkt[9+len(salt):9+len(salt)+12], f.Nonce()); diff != "" {
+		t.Errorf("Wrong nonce (-want, +got):\n%s", diff)
+	}
+	f.Salt()[0] ^= 1 // must not affect f
+	if got := f.Encode(); string(got) != string(pkt) {
+		t.Error("Modifying the result of Salt changed the file")
+	}
+	if n, r, p := f.ScryptParams(); n != 1<<15 || r != 8 || p != 1 {
+		t.Errorf("ScryptParams: got (%d, %d, %d), want (%d, 8, 1)", n, r, p, 1<<15)
+	}
+}
+
+func TestScryptOptions(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20261016112208)))
+	const passphrase = "cheap and cheerful"
+
+	f := keyfile.New(keyfile.WithScryptN(1<<10), keyfile.WithScryptR(4), keyfile.WithScryptP(2))
+	checkParams := func(f *keyfile.File) {
+		t.Helper()
+		if N, r, p := f.ScryptParams(); N != 1<<10 || r != 4 || p != 2 {
+			t.Errorf("ScryptParams: got (%d, %d, %d), want (1024, 4, 2)", N, r, p)
+		}
+	}
+	checkParams(f)
+
+	want, err := f.Random(passphrase, 32)
+	if err != nil {
+		t.Fatalf("Random: unexpected error: %v", err)
+	}
+	checkParams(f)
+
+	// The parameters are persisted, so a parsed copy can be decrypted.
+	g, err := keyfile.Parse(f.Encode())
+	if err != nil {
+		t.Fatalf("Parse: unexpected error: %v", err)
+	}
+	checkParams(g)
+	if got, err := g.Get(passphrase); err != nil {
+		t.Errorf("Get: unexpected error: %v", err)
+	} else if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Get (-want, +got):\n%s", diff)
+	}
+
+	// Storing a new secret in f keeps its settings.
+	if err := f.Rekey(passphrase, "new "+passphrase); err != nil {
+		t.Fatalf("Rekey: unexpected error: %v", err)
+	}
+	checkParams(f)
+
+	// Only the settings given are changed from the defaults.
+	if N, r, p := keyfile.New(keyfile.WithScryptR(1)).ScryptParams(); N != 1<<15 || r != 1 || p != 1 {
+		t.Errorf("ScryptParams: got (%d, %d, %d), want (32768, 1, 1)", N, r, p)
+	}
+
+	// Settings that require too much memory are reported by Set.
+	big := keyfile.New(keyfile.WithScryptN(1<<24), keyfile.WithScryptR(255))
+	if err := big.Set(passphrase, want); err == nil {
+		t.Error("Set with excessive scrypt cost: got nil, want error")
+	} else if !big.IsEmpty() {
+		t.Error("Set with excessive scrypt cost modified the file")
+	}
+
+	for _, test := range []struct {
+		name string
+		opt  func()
+	}{
+		{"WithScryptN(0)", func() { keyfile.WithScryptN(0) }},
+		{"WithScryptN(1)", func() { keyfile.WithScryptN(1) }},
+		{"WithScryptN(1000)", func() { keyfile.WithScryptN(1000) }},
+		{"WithScryptR(0)", func() { keyfile.WithScryptR(0) }},
+		{"WithScryptR(256)", func() { keyfile.WithScryptR(256) }},
+		{"WithScryptP(-1)", func() { keyfile.WithScryptP(-1) }},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: did not panic", test.name)
+				}
+			}()
+			test.opt()
+		}()
+	}
+}
+
+func TestComment(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20261016135012)))
+	const passphrase = "labelled"
+	const comment = "prod signing key, rotated 2024-06"
+
+	f := keyfile.New(keyfile.WithScryptN(1 << 10))
+	f.SetComment(comment)
+	want, err := f.Random(passphrase, 32)
+	if err != nil {
+		t.Fatalf("Random: unexpected error: %v", err)
+	}
+
+	// The comment is persisted, and visible without a passphrase.
+	pkt := f.Encode()
+	g, err := keyfile.Parse(pkt)
+	if err != nil {
+		t.Fatalf("Parse: unexpected error: %v", err)
+	}
+	if got := g.Comment(); got != comment {
+		t.Errorf("Comment: got %q, want %q", got, comment)
+	}
+	if h, err := keyfile.Inspect(pkt); err != nil {
+		t.Errorf("Inspect: unexpected error: %v", err)
+	} else if h.Comment != comment {
+		t.Errorf("Inspect: got comment %q, want %q", h.Comment, comment)
+	}
+	if got, err := g.Get(passphrase); err != nil {
+		t.Errorf("Get: unexpected error: %v", err)
+	} else if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Get (-want, +got):\n%s", diff)
+	}
+
+	// Modifying the comment in the packet prevents decryption.
+	i := strings.Index(string(pkt), comment)
+	bad := append([]byte(nil), pkt...)
+	bad[i] ^= 0x20
+	if h, err := keyfile.Parse(bad); err != nil {
+		t.Fatalf("Parse: unexpected error: %v", err)
+	} else if got, err := h.Get(passphrase); !errors.Is(err, keyfile.ErrBadPassphrase) {
+		t.Errorf("Get with modified comment: got %x, %v; want %v", got, err, keyfile.ErrBadPassphrase)
+	}
+
+	// Changing the comment after storing a key invalidates it until the
+	// secret is stored again.
+	g.SetComment("something else")
+	if err := g.Verify(passphrase); !errors.Is(err, keyfile.ErrBadPassphrase) {
+		t.Errorf("Verify after SetComment: got %v, want %v", err, keyfile.ErrBadPassphrase)
+	}
+	if err := g.Set(passphrase, want); err != nil {
+		t.Fatalf("Set: unexpected error: %v", err)
+	}
+	if err := g.Verify(passphrase); err != nil {
+		t.Errorf("Verify after Set: unexpected error: %v", err)
+	}
+
+	// Rekey preserves the comment.
+	if err := f.Rekey(passphrase, "relabelled"); err != nil {
+		t.Fatalf("Rekey: unexpected error: %v", err)
+	} else if got := f.Comment(); got != comment {
+		t.Errorf("Comment after Rekey: got %q, want %q", got, comment)
+	}
+
+	// A File without a comment encodes as before.
+	if got := keyfile.New().Header().Comment; got != "" {
+		t.Errorf("Comment of empty file: got %q, want empty", got)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("SetComment with a long comment did not panic")
+			}
+		}()
+		f.SetComment(strings.Repeat("x", keyfile.MaxCommentBytes+1))
+	}()
+}
+
+func TestInspect(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20261016101530)))
+	secret := []byte("inspect me")
+
+	scr := keyfile.New()
+	if err := scr.SetSaltSize("a", secret, 24); err != nil {
+		t.Fatalf("SetSaltSize: unexpected error: %v", err)
+	}
+	arg := keyfile.New(keyfile.WithKDF(keyfile.Argon2id), keyfile.WithCipher(keyfile.ChaCha20Poly1305))
+	if err := arg.Set("b", secret); err != nil {
+		t.Fatalf("Set: unexpected error: %v", err)
+	}
+
+	for _, test := range []struct {
+		f    *keyfile.File
+		want keyfile.Header
+	}{
+		{keyfile.New(), keyfile.Header{
+			Version: 3, KDF: keyfile.Scrypt, Cipher: keyfile.AES256GCM,
+			Params: [3]int{1 << 15, 8, 1},
+		}},
+		{scr, keyfile.Header{
+			Version: 3, KDF: keyfile.Scrypt, Cipher: keyfile.AES256GCM,
+			SaltLen: 24, NonceLen: 12, DataLen: len(secret) + 16,
+			Params: [3]int{1 << 15, 8, 1},
+		}},
+		{arg, keyfile.Header{
+			Version: 3, KDF: keyfile.Argon2id, Cipher: keyfile.ChaCha20Poly1305,
+			SaltLen: 16, NonceLen: 12, DataLen: len(secret) + 16,
+			Params: [3]int{3, 64 << 10, 4},
+		}},
+	} {
+		if diff := cmp.Diff(test.want, test.f.Header()); diff != "" {
+			t.Errorf("Header (-want, +got):\n%s", diff)
+		}
+		got, err := keyfile.Inspect(test.f.Encode())
+		if err != nil {
+			t.Errorf("Inspect: unexpected error: %v", err)
+		} else if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Inspect (-want, +got):\n%s", diff)
+		}
+	}
+
+	if got, err := keyfile.Inspect([]byte("KF\x09")); !errors.Is(err, keyfile.ErrBadPacket) {
+		t.Errorf("Inspect bad packet: got %+v, %v; want %v", got, err, keyfile.ErrBadPacket)
+	}
+
+	// Parameters weaker than the defaults are reported.
+	weak := keyfile.New(keyfile.WithScryptN(1 << 10))
+	if err := weak.Set("c", secret); err != nil {
+		t.Fatalf("Set: unexpected error: %v", err)
+	}
+	if h := weak.Header(); !h.Weak || h.Params != [3]int{1 << 10, 8, 1} {
+		t.Errorf("Header: got weak=%v params=%v, want weak=true params=[1024 8 1]", h.Weak, h.Params)
+	}
+}
+
+func TestConcurrentGet(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20241018160245)))
+	const passphrase = "many hands"
+
+	f := keyfile.New()
+	want, err := f.Random(passphrase, 32)
+	if err != nil {
+		t.Fatalf("Random(32) failed: %v", err)
+	}
+
+	// Readers may share f without synchronization.
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got, err := f.Get(passphrase); err != nil {
+				t.Errorf("Get: unexpected error: %v", err)
+			} else if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("Wrong key value (-want, +got):\n%s", diff)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// A writer requires exclusive access, here provided by the caller.
+	var mu sync.RWMutex
+	for i := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i == 0 {
+				mu.Lock()
+				defer mu.Unlock()
+				if err := f.Set(passphrase, want); err != nil {
+					t.Errorf("Set: unexpected error: %v", err)
+				}
+				return
+			}
+			mu.RLock()
+			defer mu.RUnlock()
+			if got, err := f.Get(passphrase); err != nil {
+				t.Errorf("Get: unexpected error: %v", err)
+			} else if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("Wrong key value (-want, +got):\n%s", diff)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSetSaltSize(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20241018171404)))
+	const passphrase = "pass the salt"
+	secret := []byte("seasoned")
+
+	f := keyfile.New()
+	if err := f.SetSaltSize(passphrase, secret, 32); err != nil {
+		t.Fatalf("SetSaltSize(32): unexpected error: %v", err)
+	}
+	if got := len(f.Salt()); got != 32 {
+		t.Errorf("Salt length: got %d, want 32", got)
+	}
+	g, err := keyfile.Parse(f.Encode())
+	if err != nil {
+		t.Fatalf("Parse: unexpected error: %v", err)
+	}
+	if got, err := g.Get(passphrase); err != nil {
+		t.Errorf("Get: unexpected error: %v", err)
+	} else if diff := cmp.Diff(secret, got); diff != "" {
+		t.Errorf("Wrong key value (-want, +got):\n%s", diff)
+	}
+
+	want := f.Encode()
+	for _, bad := range []int{0, keyfile.MinSaltBytes - 1, keyfile.MaxSaltBytes + 1} {
+		if err := f.SetSaltSize(passphrase, secret, bad); err == nil {
+			t.Errorf("SetSaltSize(%d): got nil, want error", bad)
+		}
+	}
+	if got := f.Encode(); string(got) != string(want) {
+		t.Error("Failed SetSaltSize modified the file")
+	}
+}
+
+func TestSetDeterministic(t *testing.T) {
+	const passphrase = "reproducible"
+	salt := []byte("0123456789abcdef")
+	secret := []byte("golden secret")
+
+	var f, g keyfile.File
+	if err := f.SetDeterministic(passphrase, secret, salt); err != nil {
+		t.Fatalf("SetDeterministic: unexpected error: %v", err)
+	}
+	if err := g.SetDeterministic(passphrase, secret, salt); err != nil {
+		t.Fatalf("SetDeterministic: unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(f.Encode(), g.Encode()); diff != "" {
+		t.Errorf("Packets differ for the same inputs (-first, +second):\n%s", diff)
+	}
+	if got, err := f.Get(passphrase); err != nil {
+		t.Errorf("Get: unexpected error: %v", err)
+	} else if diff := cmp.Diff(secret, got); diff != "" {
+		t.Errorf("Wrong key value (-want, +got):\n%s", diff)
+	}
+
+	// A different secret must not reuse the nonce.
+	var h keyfile.File
+	if err := h.SetDeterministic(passphrase, []byte("other secret"), salt); err != nil {
+		t.Fatalf("SetDeterministic: unexpected error: %v", err)
+	}
+	if fn, hn := f.Nonce(), h.Nonce(); string(fn) == string(hn) {
+		t.Errorf("Nonce reused for different secrets: %x", fn)
+	}
+
+	// Neither may a different comment, which is part of the additional data.
+	seen := map[string]string{string(f.Nonce()): ""}
+	for _, comment := range []string{"first", "second"} {
+		var c keyfile.File
+		c.SetComment(comment)
+		if err := c.SetDeterministic(passphrase, secret, salt); err != nil {
+			t.Fatalf("SetDeterministic: unexpected error: %v", err)
+		}
+		if prev, ok := seen[string(c.Nonce())]; ok {
+			t.Errorf("Nonce reused for comments %q and %q: %x", prev, comment, c.Nonce())
+		}
+		seen[string(c.Nonce())] = comment
+	}
+
+	for _, bad := range [][]byte{nil, make([]byte, 256)} {
+		if err := f.SetDeterministic(passphrase, secret, bad); err == nil {
+			t.Errorf("SetDeterministic(salt %d bytes): got nil, want error", len(bad))
+		}
+	}
+}
+
+func TestRekey(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20241019130452)))
+	const oldPass, newPass = "yesterday", "tomorrow"
+	secret := []byte("rotating")
+
+	f := keyfile.New()
+	if err := f.SetSaltSize(oldPass, secret, 24); err != nil {
+		t.Fatalf("SetSaltSize: unexpected error: %v", err)
+	}
+	before := f.Encode()
+	if err := f.Rekey("wrong", newPass); !errors.Is(err, keyfile.ErrBadPassphrase) {
+		t.Errorf("Rekey with wrong passphrase: got %v, want %v", err, keyfile.ErrBadPassphrase)
+	}
+	if diff := cmp.Diff(before, f.Encode()); diff != "" {
+		t.Errorf("Failed Rekey modified the file (-want, +got):\n%s", diff)
+	}
+
+	if err := f.Rekey(oldPass, newPass); err != nil {
+		t.Fatalf("Rekey: unexpected error: %v", err)
+	}
+	if got := len(f.Salt()); got != 24 {
+		t.Errorf("Salt length after Rekey: got %d, want 24", got)
+	}
+	if got, err := f.Get(newPass); err != nil {
+		t.Errorf("Get(new): unexpected error: %v", err)
+	} else if diff := cmp.Diff(secret, got); diff != "" {
+		t.Errorf("Wrong key value (-want, +got):\n%s", diff)
+	}
+	if _, err := f.Get(oldPass); !errors.Is(err, keyfile.ErrBadPassphrase) {
+		t.Errorf("Get(old) after Rekey: got %v, want %v", err, keyfile.ErrBadPassphrase)
+	}
+
+	// Rekey keeps the stored cost of a parsed packet that is at least as
+	// strong as the default, and raises one that is weaker. A larger N does
+	// not make up for a smaller r.
+	for _, test := range []struct {
+		name    string
+		opts    []keyfile.Option
+		weak    bool
+		N, r, p int
+	}{
+		{"N=2^16", []keyfile.Option{keyfile.WithScryptN(1 << 16)}, false, 1 << 16, 8, 1},
+		{"N=2^10", []keyfile.Option{keyfile.WithScryptN(1 << 10)}, true, 1 << 15, 8, 1},
+		{"r=1", []keyfile.Option{keyfile.WithScryptR(1)}, true, 1 << 15, 8, 1},
+		{"N=2^16,r=1", []keyfile.Option{keyfile.WithScryptN(1 << 16), keyfile.WithScryptR(1)}, true, 1 << 15, 8, 1},
+		{"N=2^16,r=4", []keyfile.Option{keyfile.WithScryptN(1 << 16), keyfile.WithScryptR(4)}, false, 1 << 16, 4, 1},
+	} {
+		opts := append(test.opts, keyfile.WithCipher(keyfile.ChaCha20Poly1305))
+		g := keyfile.New(opts...)
+		if err := g.Set(oldPass, secret); err != nil {
+			t.Fatalf("Set: unexpected error: %v", err)
+		}
+		h, err := keyfile.Parse(g.Encode())
+		if err != nil {
+			t.Fatalf("Parse: unexpected error: %v", err)
+		}
+		if got := h.Header().Weak; got != test.weak {
+			t.Errorf("Header %s: got Weak=%v, want %v", test.name, got, test.weak)
+		}
+		if err := h.Rekey(oldPass, newPass); err != nil {
+			t.Fatalf("Rekey: unexpected error: %v", err)
+		}
+		if N, r, p := h.ScryptParams(); N != test.N || r != test.r || p != test.p {
+			t.Errorf("Rekey %s: got params (%d, %d, %d), want (%d, %d, %d)", test.name, N, r, p, test.N, test.r, test.p)
+		}
+		if c := h.Cipher(); c != keyfile.ChaCha20Poly1305 {
+			t.Errorf("Rekey %s: got cipher %v, want %v", test.name, c, keyfile.ChaCha20Poly1305)
+		}
+		if err := h.Verify(newPass); err != nil {
+			t.Errorf("Verify after Rekey: unexpected error: %v", err)
+		}
+	}
+}
+
+func TestAAD(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20241019123015)))
+	const passphrase = "bound to context"
+	secret, aad := []byte("tenant key"), []byte("tenant-42.example.com")
+
+	f := keyfile.New()
+	if err := f.SetAAD(passphrase, secret, aad); err != nil {
+		t.Fatalf("SetAAD: unexpected error: %v", err)
+	}
+	if strings.Contains(string(f.Encode()), string(aad)) {
+		t.Error("Encoded packet contains the AAD")
+	}
+	if got, err := f.GetAAD(passphrase, aad); err != nil {
+		t.Errorf("GetAAD: unexpected error: %v", err)
+	} else if diff := cmp.Diff(secret, got); diff != "" {
+		t.Errorf("Wrong key value (-want, +got):\n%s", diff)
+	}
+	for _, bad := range [][]byte{nil, []byte("tenant-43.example.com")} {
+		if got, err := f.GetAAD(passphrase, bad); !errors.Is(err, keyfile.ErrBadPassphrase) {
+			t.Errorf("GetAAD(%q): got %q, %v; want %v", bad, got, err, keyfile.ErrBadPassphrase)
+		}
+	}
+	if got, err := f.Get(passphrase); !errors.Is(err, keyfile.ErrBadPassphrase) {
+		t.Errorf("Get: got %q, %v; want %v", got, err, keyfile.ErrBadPassphrase)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20261016114730)))
+	f := keyfile.New(keyfile.WithScryptN(1 << 10))
+	if err := f.Set("foobar", []byte("hidden")); err != nil {
+		t.Fatalf("Set: unexpected error: %v", err)
+	}
+	if err := f.Verify("foobar"); err != nil {
+		t.Errorf("Verify: unexpected error: %v", err)
+	}
+	if err := f.Verify("wrong"); !errors.Is(err, keyfile.ErrBadPassphrase) {
+		t.Errorf("Verify(wrong): got %v, want %v", err, keyfile.ErrBadPassphrase)
+	}
+	if err := keyfile.New().Verify("foobar"); !errors.Is(err, keyfile.ErrNoKey) {
+		t.Errorf("Verify(empty): got %v, want %v", err, keyfile.ErrNoKey)
+	}
+}
+
+func TestGetFixed(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20241016081522)))
+	const passphrase = "twenty-one pilots"
+
+	f := keyfile.New()
+	rnd, err := f.Random(passphrase, 32)
+	if err != nil {
+		t.Fatalf("Random(32) failed: %v", err)
+	}
+	if got, err := f.Get32(passphrase); err != nil {
+		t.Errorf("Get32: unexpected error: %v", err)
+	} else if diff := cmp.Diff(rnd, got[:]); diff != "" {
+		t.Errorf("Get32: wrong key value (-want, +got):\n%s", diff)
+	}
+	if got, err := f.Get16(passphrase); !errors.Is(err, keyfile.ErrSecretWrongSize) {
+		t.Errorf("Get16: got %x, %v; want %v", got, err, keyfile.ErrSecretWrongSize)
+	}
+	if got, err := f.Get32("wrong"); err == nil {
+		t.Errorf("Get32 with wrong passphrase: got %x, want error", got)
+	}
+}
+
+func TestGetInto(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20241019134128)))
+	const passphrase = "caller owned"
+
+	f := keyfile.New()
+	rnd, err := f.Random(passphrase, 20)
+	if err != nil {
+		t.Fatalf("Random(20) failed: %v", err)
+	}
+	buf := make([]byte, 32)
+	if n, err := f.GetInto(buf, passphrase); err != nil {
+		t.Errorf("GetInto: unexpected error: %v", err)
+	} else if diff := cmp.Diff(rnd, buf[:n]); diff != "" {
+		t.Errorf("Wrong key value (-want, +got):\n%s", diff)
+	}
+	if n, err := f.GetInto(make([]byte, 19), passphrase); !errors.Is(err, keyfile.ErrSecretWrongSize) {
+		t.Errorf("GetInto(short): got %d, %v; want %v", n, err, keyfile.ErrSecretWrongSize)
+	}
+	if n, err := f.GetInto(buf, "wrong"); !errors.Is(err, keyfile.ErrBadPassphrase) {
+		t.Errorf("GetInto(wrong): got %d, %v; want %v", n, err, keyfile.ErrBadPassphrase)
+	}
+}
+
+func TestDerive(t *testing.T) {
+	const passphrase = "master of keys"
+	f := keyfile.New()
+	if err := f.SetDeterministic(passphrase, []byte("master secret"), []byte("derive salt")); err != nil {
+		t.Fatalf("SetDeterministic: unexpected error: %v", err)
+	}
+
+	enc, err := f.Derive(passphrase, []byte("encryption"), 32)
+	if err != nil {
+		t.Fatalf("Derive(encryption): unexpected error: %v", err)
+	}
+	mac, err := f.Derive(passphrase, []byte("mac"), 32)
+	if err != nil {
+		t.Fatalf("Derive(mac): unexpected error: %v", err)
+	}
+	if len(enc) != 32 || len(mac) != 32 {
+		t.Errorf("Derive: got lengths %d, %d; want 32", len(enc), len(mac))
+	}
+	if string(enc) == string(mac) {
+		t.Error("Derive: different labels produced the same subkey")
+	}
+	if again, err := f.Derive(passphrase, []byte("encryption"), 32); err != nil {
+		t.Errorf("Derive(encryption): unexpected error: %v", err)
+	} else if diff := cmp.Diff(enc, again); diff != "" {
+		t.Errorf("Derive is not reproducible (-first, +second):\n%s", diff)
+	}
+
+	// Check a known value, so that the derivation is stable across versions.
+	const want = "23e2484338a5ac3eb6efbac2c1bb8c67c9ebe636c5222701dd0bbdb7b72b1e0d"
+	if got := fmt.Sprintf("%x", enc); got != want {
+		t.Errorf("Derive(encryption): got %s, want %s", got, want)
+	}
+
+	for _, n := range []int{0, -1, 255*32 + 1} {
+		if got, err := f.Derive(passphrase, nil, n); err == nil {
+			t.Errorf("Derive(%d): got %x, want error", n, got)
+		}
+	}
+	if got, err := f.Derive("wrong", nil, 16); !errors.Is(err, keyfile.ErrBadPassphrase) {
+		t.Errorf("Derive with wrong passphrase: got %x, %v; want %v", got, err, keyfile.ErrBadPassphrase)
+	}
+}
+
+func TestLoadKeyLimit(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20241016082210)))
+	const passphrase = "bounded"
+
+	f := keyfile.New()
+	rnd, err := f.Random(passphrase, 1024)
+	if err != nil {
+		t.Fatalf("Random(1024) failed: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "test.key")
+	if err := os.WriteFile(path, f.Encode(), 0600); err != nil {
+		t.Fatalf("Write keyfile: %v", err)
+	}
+
+	pf := func() (string, error) { return passphrase, nil }
+	if got, err := keyfile.LoadKeyLimit(path, 0, pf); err != nil {
+		t.Errorf("LoadKeyLimit(default): unexpected error: %v", err)
+	} else if diff := cmp.Diff(rnd, got); diff != "" {
+		t.Errorf("Wrong key value (-want, +got):\n%s", diff)
+	}
+
+	called := false
+	got, err := keyfile.LoadKeyLimit(path, 512, func() (string, error) {
+		called = true
+		return passphrase, nil
+	})
+	if !errors.Is(err, keyfile.ErrFileTooLarge) {
+		t.Errorf("LoadKeyLimit(512): got %d bytes, %v; want %v", len(got), err, keyfile.ErrFileTooLarge)
+	}
+	if called {
+		t.Error("LoadKeyLimit(512): passphrase was requested for an oversized file")
+	}
+}
+
+func TestLoadKeyFS(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20261016093412)))
+	const passphrase = "embedded"
+
+	f := keyfile.New()
+	want, err := f.Random(passphrase, 32)
+	if err != nil {
+		t.Fatalf("Random(32) failed: %v", err)
+	}
+	fsys := fstest.MapFS{
+		"keys/test.key": {Data: f.Encode()},
+		"keys/bad.key":  {Data: []byte("KF\x09")},
+		"big.key":       {Data: make([]byte, keyfile.DefaultMaxFileSize+1)},
+	}
+	pf := func() (string, error) { return passphrase, nil }
+
+	if got, err := keyfile.LoadKeyFS(fsys, "keys/test.key", pf); err != nil {
+		t.Errorf("LoadKeyFS: unexpected error: %v", err)
+	} else if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("LoadKeyFS (-want, +got):\n%s", diff)
+	}
+
+	for _, test := range []struct {
+		path string
+		want error
+	}{
+		{"keys/missing.key", fs.ErrNotExist},
+		{"keys/bad.key", keyfile.ErrBadPacket},
+		{"keys", keyfile.ErrNotRegularFile},
+		{"big.key", keyfile.ErrFileTooLarge},
+	} {
+		if got, err := keyfile.LoadKeyFS(fsys, test.path, pf); !errors.Is(err, test.want) {
+			t.Errorf("LoadKeyFS(%q): got %q, %v; want %v", test.path, got, err, test.want)
+		}
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20241020101523)))
+	f := keyfile.New()
+	if err := f.Set("read me", []byte("contents")); err != nil {
+		t.Fatalf("Set: unexpected error: %v", err)
+	}
+	dir := t.TempDir()
+	for name, data := range map[string][]byte{
+		"test.key": f.Encode(),
+		"bad.key":  []byte("KF\x09"),
+		"big.key":  make([]byte, keyfile.DefaultMaxFileSize+1),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatalf("Write %q: %v", name, err)
+		}
+	}
+
+	if g, err := keyfile.ReadFile(filepath.Join(dir, "test.key")); err != nil {
+		t.Errorf("ReadFile: unexpected error: %v", err)
+	} else if diff := cmp.Diff(f.Encode(), g.Encode()); diff != "" {
+		t.Errorf("ReadFile (-want, +got):\n%s", diff)
+	}
+
+	for _, test := range []struct {
+		path string
+		want error
+	}{
+		{"missing.key", fs.ErrNotExist},
+		{"bad.key", keyfile.ErrBadPacket},
+		{".", keyfile.ErrNotRegularFile},
+		{"big.key", keyfile.ErrFileTooLarge},
+	} {
+		if got, err := keyfile.ReadFile(filepath.Join(dir, test.path)); !errors.Is(err, test.want) {
+			t.Errorf("ReadFile(%q): got %v, %v; want %v", test.path, got, err, test.want)
+		}
+	}
+}
+
+func TestLoadKeyNotRegular(t *testing.T) {
+	dir := t.TempDir()
+	key, err := keyfile.LoadKey(dir, func() (string, error) {
+		t.Error("LoadKey requested a passphrase for a directory")
+		return "", nil
+	})
+	if !errors.Is(err, keyfile.ErrNotRegularFile) {
+		t.Errorf("LoadKey(%q): got %q, %v; want %v", dir, key, err, keyfile.ErrNotRegularFile)
+	}
+}
+
+func TestLoadKeyResult(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20241018174730)))
+	const passphrase = "tell me more"
+	pf := func() (string, error) { return passphrase, nil }
+	dir := t.TempDir()
+
+	for _, test := range []struct {
+		saltBytes int
+		weak      bool
+	}{
+		{keyfile.DefaultSaltBytes, false},
+		{keyfile.MinSaltBytes, true},
+	} {
+		f := keyfile.New()
+		secret := []byte("observable")
+		if err := f.SetSaltSize(passphrase, secret, test.saltBytes); err != nil {
+			t.Fatalf("SetSaltSize(%d): unexpected error: %v", test.saltBytes, err)
+		}
+		path := filepath.Join(dir, "test.key")
+		if err := os.WriteFile(path, f.Encode(), 0600); err != nil {
+			t.Fatalf("Write keyfile: %v", err)
+		}
+		res, err := keyfile.LoadKeyResult(path, pf)
+		if err != nil {
+			t.Fatalf("LoadKeyResult: unexpected error: %v", err)
+		}
+		if diff := cmp.Diff(secret, res.Key); diff != "" {
+			t.Errorf("Wrong key value (-want, +got):\n%s", diff)
+		}
+		if res.Version != 3 || res.KDF != keyfile.Scrypt || res.Legacy || res.Weak != test.weak {
+			t.Errorf("LoadKeyResult(salt %d): got %+v, want version 3, scrypt, weak=%v",
+				test.saltBytes, res, test.weak)
+		}
+		if res.Derivation <= 0 {
+			t.Errorf("LoadKeyResult: got derivation time %v, want > 0", res.Derivation)
+		}
+	}
+}
+
+func TestSaveKey(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20261016105044)))
+	const passphrase = "rewritable"
+	path := filepath.Join(t.TempDir(), "test.key")
+	pf := func() (string, error) { return passphrase, nil }
+
+	for _, secret := range []string{"first secret", "second secret"} {
+		if err := keyfile.SaveKey(path, []byte(secret), pf); err != nil {
+			t.Fatalf("SaveKey: unexpected error: %v", err)
+		}
+		if got, err := keyfile.LoadKey(path, pf); err != nil {
+			t.Errorf("LoadKey: unexpected error: %v", err)
+		} else if string(got) != secret {
+			t.Errorf("LoadKey: got %q, want %q", got, secret)
+		}
+	}
+
+	// With NoOverwrite, an existing file is preserved.
+	err := keyfile.SaveKeyOptions(path, []byte("third secret"), func() (string, error) {
+		t.Error("SaveKeyOptions requested a passphrase for an existing file")
+		return passphrase, nil
+	}, &keyfile.SaveOptions{NoOverwrite: true})
+	if !errors.Is(err, keyfile.ErrExists) {
+		t.Errorf("SaveKeyOptions(existing): got %v, want %v", err, keyfile.ErrExists)
+	}
+	if got, err := keyfile.LoadKey(path, pf); err != nil {
+		t.Errorf("LoadKey: unexpected error: %v", err)
+	} else if string(got) != "second secret" {
+		t.Errorf("Key changed after failed save: got %q", got)
+	}
+
+	// A passphrase error is reported, and nothing is written.
+	other := filepath.Join(t.TempDir(), "other.key")
+	perr := errors.New("no passphrase")
+	if err := keyfile.SaveKey(other, []byte("x"), func() (string, error) { return "", perr }); !errors.Is(err, perr) {
+		t.Errorf("SaveKey: got %v, want %v", err, perr)
+	}
+	if _, err := os.Stat(other); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat after failed save: got %v, want %v", err, fs.ErrNotExist)
+	}
+}
+
+func TestSaveKeyIfAbsent(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20241018143522)))
+	const passphrase = "open sesame"
+	path := filepath.Join(t.TempDir(), "test.key")
+	pf := func() (string, error) { return passphrase, nil }
+
+	secret := []byte("first secret")
+	if err := keyfile.SaveKeyIfAbsent(path, secret, pf); err != nil {
+		t.Fatalf("SaveKeyIfAbsent: unexpected error: %v", err)
+	}
+	if got, err := keyfile.LoadKey(path, pf); err != nil {
+		t.Errorf("LoadKey: unexpected error: %v", err)
+	} else if diff := cmp.Diff(secret, got); diff != "" {
+		t.Errorf("Wrong key value (-want, +got):\n%s", diff)
+	}
+
+	// A second save must fail without prompting and leave the file intact.
+	err := keyfile.SaveKeyIfAbsent(path, []byte("second secret"), func() (string, error) {
+		t.Error("SaveKeyIfAbsent requested a passphrase for an existing file")
+		return passphrase, nil
+	})
+	if !errors.Is(err, keyfile.ErrExists) {
+		t.Errorf("SaveKeyIfAbsent(existing): got %v, want %v", err, keyfile.ErrExists)
+	}
+	if got, err := keyfile.LoadKey(path, pf); err != nil {
+		t.Errorf("LoadKey: unexpected error: %v", err)
+	} else if diff := cmp.Diff(secret, got); diff != "" {
+		t.Errorf("Key changed after failed save (-want, +got):\n%s", diff)
+	}
+
+	// No temporary files are left behind.
+	des, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if len(des) != 1 || des[0].Name() != filepath.Base(path) {
+		var names []string
+		for _, de := range des {
+			names = append(names, de.Name())
+		}
+		t.Errorf("Directory contents: got %q, want only %q", names, filepath.Base(path))
+	}
+}
+
+func TestLoadAll(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20241016090133)))
+	dir := t.TempDir()
+	passphrases := map[string]string{
+		filepath.Join(dir, "alpha.key"): "first passphrase",
+		filepath.Join(dir, "bravo.key"): "second passphrase",
+		filepath.Join(dir, "wrong.key"): "third passphrase",
+	}
+	want := make(map[string][]byte)
+	for path, pp := range passphrases {
+		f := keyfile.New()
+		key, err := f.Random(pp, 16)
+		if err != nil {
+			t.Fatalf("Random(16) failed: %v", err)
+		}
+		if err := os.WriteFile(path, f.Encode(), 0600); err != nil {
+			t.Fatalf("Write keyfile: %v", err)
+		}
+		want[path] = key
+	}
+	junk := filepath.Join(dir, "junk.txt")
+	if err := os.WriteFile(junk, []byte("not a keyfile"), 0600); err != nil {
+		t.Fatalf("Write junk: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "subdir"), 0700); err != nil {
+		t.Fatalf("Create subdirectory: %v", err)
+	}
+
+	// A symlink to a keyfile elsewhere, as in a Kubernetes secret volume.
+	sub := filepath.Join(dir, "subdir", "charlie.key")
+	f := keyfile.New()
+	key, err := f.Random("fourth passphrase", 16)
+	if err != nil {
+		t.Fatalf("Random(16) failed: %v", err)
+	}
+	if err := os.WriteFile(sub, f.Encode(), 0600); err != nil {
+		t.Fatalf("Write keyfile: %v", err)
+	}
+	link := filepath.Join(dir, "charlie.key")
+	if err := os.Symlink(filepath.Join("subdir", "charlie.key"), link); err != nil {
+		t.Fatalf("Create symlink: %v", err)
+	}
+	passphrases[link] = "fourth passphrase"
+	want[link] = key
+
+	wrong := filepath.Join(dir, "wrong.key")
+	delete(want, wrong)
+	keys, errs := keyfile.LoadAll(dir, func(path string) (string, error) {
+		if path == wrong {
+			return "incorrect", nil
+		}
+		return passphrases[path], nil
+	})
+	if diff := cmp.Diff(want, keys); diff != "" {
+		t.Errorf("LoadAll keys (-want, +got):\n%s", diff)
+	}
+	if len(errs) != 2 {
+		t.Errorf("LoadAll: got %d errors, want 2: %v", len(errs), errs)
+	}
+	if err := errs[junk]; !errors.Is(err, keyfile.ErrBadPacket) {
+		t.Errorf("LoadAll %q: got error %v, want %v", junk, err, keyfile.ErrBadPacket)
+	}
+	if err := errs[wrong]; err == nil {
+		t.Errorf("LoadAll %q: got nil error, want failure", wrong)
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20261016121845)))
+	const passphrase = "one for all"
+	dir := t.TempDir()
+	write := func(name string, f *keyfile.File) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, f.Encode(), 0600); err != nil {
+			t.Fatalf("Write keyfile: %v", err)
+		}
+		return path
+	}
+
+	want := make(map[string][]byte)
+	for i, name := range []string{"alpha.key", "bravo.key", "charlie.key"} {
+		f := keyfile.New(keyfile.WithScryptN(1 << 10))
+		secret := []byte(fmt.Sprintf("secret %d", i+1))
+		var err error
+		if i < 2 {
+			err = f.SetDeterministic(passphrase, secret, []byte("shared salt")) // same derived key
+		} else {
+			err = f.Set(passphrase, secret)
+		}
+		if err != nil {
+			t.Fatalf("Set %q: unexpected error: %v", name, err)
+		}
+		want[write(name, f)] = secret
+	}
+	other := keyfile.New(keyfile.WithScryptN(1 << 10))
+	if err := other.Set("another passphrase", []byte("other")); err != nil {
+		t.Fatalf("Set: unexpected error: %v", err)
+	}
+	wrong := write("wrong.key", other)
+	junk := filepath.Join(dir, "junk.txt")
+	if err := os.WriteFile(junk, []byte("not a keyfile"), 0600); err != nil {
+		t.Fatalf("Write junk: %v", err)
+	}
+	missing := filepath.Join(dir, "missing.key")
+
+	var calls int
+	pf := func() (string, error) { calls++; return passphrase, nil }
+	paths := []string{wrong, junk, missing}
+	for path := range want {
+		paths = append(paths, path)
+	}
+	keys, err := keyfile.LoadKeys(paths, pf)
+	if calls != 1 {
+		t.Errorf("LoadKeys: passphrase requested %d times, want 1", calls)
+	}
+	if diff := cmp.Diff(want, keys); diff != "" {
+		t.Errorf("LoadKeys keys (-want, +got):\n%s", diff)
+	}
+	var lerr keyfile.LoadError
+	if !errors.As(err, &lerr) {
+		t.Fatalf("LoadKeys: got error %v, want LoadError", err)
+	} else if len(lerr) != 3 {
+		t.Errorf("LoadKeys: got %d errors, want 3: %v", len(lerr), lerr)
+	}
+	for path, want := range map[string]error{
+		wrong:   keyfile.ErrBadPassphrase,
+		junk:    keyfile.ErrBadPacket,
+		missing: fs.ErrNotExist,
+	} {
+		if got := lerr[path]; !errors.Is(got, want) {
+			t.Errorf("LoadKeys %q: got error %v, want %v", path, got, want)
+		}
+	}
+	if !errors.Is(err, keyfile.ErrBadPassphrase) {
+		t.Errorf("LoadKeys: error %v does not match %v", err, keyfile.ErrBadPassphrase)
+	}
+
+	// If no file can be parsed, the passphrase is not requested.
+	calls = 0
+	if _, err := keyfile.LoadKeys([]string{junk, missing}, pf); err == nil {
+		t.Error("LoadKeys: got nil error, want failure")
+	} else if calls != 0 {
+		t.Errorf("LoadKeys: passphrase requested %d times, want 0", calls)
+	}
+
+	// With no failures, the error is nil.
+	if _, err := keyfile.LoadKeys(paths[3:], pf); err != nil {
+		t.Errorf("LoadKeys: unexpected error: %v", err)
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	f := mustParse(b, 32)
+	b.ReportAllocs()
+	for range b.N {
+		f.Encode()
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	pkt := mustParse(b, 32).Encode()
+	b.ReportAllocs()
+	for range b.N {
+		if _, err := keyfile.Parse(pkt); err != nil {
+			b.Fatalf("Parse: %v", err)
+		}
+	}
+}
+
+// mustParse returns a file holding a synthetic (not decryptable) packet with
+// a standard salt and nonce and a payload of the given size.
+func mustParse(tb testing.TB, size int) *keyfile.File {
+	tb.Helper()
+	pkt := append([]byte("KF\x03\x10\x0c\x00\x0f\x08\x01"), make([]byte, 16+12+size+16)...)
+	f, err := keyfile.Parse(pkt)
+	if err != nil {
+		tb.Fatalf("Parse: %v", err)
+	}
+	return f
+}
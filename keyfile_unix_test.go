@@ -0,0 +1,61 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+//go:build unix
+
+package keyfile_test
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/creachadair/keyfile"
+)
+
+func TestLoadKeyPipe(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pipe")
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		t.Fatalf("Create pipe: %v", err)
+	}
+
+	// If LoadKey tried to open the pipe for reading, this would block.
+	key, err := keyfile.LoadKey(path, func() (string, error) {
+		t.Error("LoadKey requested a passphrase for a pipe")
+		return "", nil
+	})
+	if !errors.Is(err, keyfile.ErrNotRegularFile) {
+		t.Errorf("LoadKey(%q): got %q, %v; want %v", path, key, err, keyfile.ErrNotRegularFile)
+	}
+	if f, err := keyfile.ReadFile(path); !errors.Is(err, keyfile.ErrNotRegularFile) {
+		t.Errorf("ReadFile(%q): got %v, %v; want %v", path, f, err, keyfile.ErrNotRegularFile)
+	}
+}
+
+func TestSaveKeyMode(t *testing.T) {
+	dir := t.TempDir()
+	pf := func() (string, error) { return "mode", nil }
+	for _, test := range []struct {
+		name string
+		opts *keyfile.SaveOptions
+		want fs.FileMode
+	}{
+		{"default.key", nil, 0600},
+		{"shared.key", &keyfile.SaveOptions{Mode: 0640}, 0640},
+		{"absent.key", &keyfile.SaveOptions{Mode: 0640, NoOverwrite: true}, 0640},
+	} {
+		path := filepath.Join(dir, test.name)
+		if err := keyfile.SaveKeyOptions(path, []byte("secret"), pf, test.opts); err != nil {
+			t.Fatalf("SaveKeyOptions(%q): unexpected error: %v", path, err)
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Stat: %v", err)
+		}
+		if got := fi.Mode().Perm(); got != test.want {
+			t.Errorf("Mode of %q: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
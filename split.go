@@ -0,0 +1,174 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package keyfile
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+
+	"github.com/creachadair/keyfile/internal/shamir"
+)
+
+var (
+	// ErrBadShare is reported by Combine when a share is malformed or is
+	// inconsistent with the other shares.
+	ErrBadShare = errors.New("invalid share")
+
+	// ErrTooFewShares is reported by Combine when it is given fewer shares
+	// than are required to recover the secret.
+	ErrTooFewShares = errors.New("too few shares")
+)
+
+// shareMagic is the format tag for an encoded share.
+//
+// An encoded share is structured as follows:
+//
+//	Pos     Len     Description
+//	0       3       Format tag, "KS\x01" == "\x4b\x53\x01"
+//	3       1       Number of shares required to recover the secret
+//	4       8       Split ID, random and the same for all shares of a split
+//	12      8       Checksum of the secret (see shareChecksum)
+//	20      slen    Share value, one byte per byte of the secret
+//	20+slen 1       Share index (nonzero)
+const shareMagic = "KS\x01"
+
+const (
+	splitIDBytes  = 8
+	checksumBytes = 8
+	shareHdrBytes = len(shareMagic) + 1 + splitIDBytes + checksumBytes
+)
+
+// shareChecksum returns a truncated hash of secret, recorded in each share so
+// that Combine can detect a wrong result.
+func shareChecksum(secret []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte("keyfile share\x00"))
+	h.Write(secret)
+	return h.Sum(nil)[:checksumBytes]
+}
+
+// Split decrypts the key from f using the given passphrase and splits it into
+// the specified number of shares, any threshold of which are sufficient to
+// recover the key with Combine. It requires 2 ≤ threshold ≤ shares ≤ 255.
+//
+// Each share records its index and the threshold, so shares may be combined
+// in any order. Each share also records a random ID for the split and a short
+// checksum of the key, which Combine uses to detect shares from different
+// splits. Like a Diff fingerprint, the checksum of a low-entropy key can be
+// checked against guesses. The shares are not encrypted; the caller is
+// responsible for protecting them, for example by storing each one in its own
+// File.
+func (f *File) Split(passphrase string, shares, threshold int) ([][]byte, error) {
+	key, err := f.Get(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(key)
+	raw, err := shamir.Split(key, shares, threshold)
+	if err != nil {
+		return nil, err
+	}
+	hdr := make([]byte, shareHdrBytes)
+	n := copy(hdr, shareMagic)
+	hdr[n] = byte(threshold)
+	if _, err := crand.Read(hdr[n+1 : n+1+splitIDBytes]); err != nil {
+		return nil, err
+	}
+	copy(hdr[n+1+splitIDBytes:], shareChecksum(key))
+
+	out := make([][]byte, len(raw))
+	for i, r := range raw {
+		out[i] = append(append(make([]byte, 0, len(hdr)+len(r)), hdr...), r...)
+		clear(r)
+	}
+	return out, nil
+}
+
+// Combine recovers a secret from shares produced by Split. It reports
+// ErrTooFewShares if fewer shares are given than the threshold recorded in
+// them, and ErrBadShare if any of the shares is malformed, the shares are not
+// from the same split, or the recovered secret does not match the checksum
+// recorded in the shares (for example, because a share was altered).
+func Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, fmt.Errorf("%w: no shares given", ErrTooFewShares)
+	}
+	var hdr []byte // threshold, split ID, and checksum, shared by all
+	raw := make([][]byte, len(shares))
+	for i, s := range shares {
+		if !bytes.HasPrefix(s, []byte(shareMagic)) || len(s) < shareHdrBytes+2 {
+			return nil, fmt.Errorf("%w: share %d is malformed", ErrBadShare, i+1)
+		}
+		h := s[len(shareMagic):shareHdrBytes]
+		if hdr == nil {
+			hdr = h
+		} else if h[0] != hdr[0] {
+			return nil, fmt.Errorf("%w: share %d has threshold %d, want %d", ErrBadShare, i+1, h[0], hdr[0])
+		} else if !bytes.Equal(h, hdr) {
+			return nil, fmt.Errorf("%w: share %d is from a different split", ErrBadShare, i+1)
+		}
+		raw[i] = s[shareHdrBytes:]
+	}
+	threshold := int(hdr[0])
+	if len(shares) < threshold {
+		return nil, fmt.Errorf("%w: have %d, need %d", ErrTooFewShares, len(shares), threshold)
+	}
+	secret, err := shamir.Combine(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadShare, err)
+	}
+	if subtle.ConstantTimeCompare(shareChecksum(secret), hdr[1+splitIDBytes:]) != 1 {
+		clear(secret)
+		return nil, fmt.Errorf("%w: recovered secret does not match its checksum", ErrBadShare)
+	}
+	return secret, nil
+}
+
+// SetSplit encrypts secret with the passphrase and stores it in f, as Set
+// does, except that what f stores is the XOR of secret with tokenShare. The
+// secret can then be recovered only by GetSplit with both the passphrase and
+// the same tokenShare, which is typically held outside the file (for example,
+// on a hardware token).
+//
+// The tokenShare must be the same length as secret and should be uniformly
+// random; if it is, neither the file (with its passphrase) nor the token
+// share alone reveals anything about the secret.
+func (f *File) SetSplit(passphrase string, secret, tokenShare []byte) error {
+	if len(tokenShare) != len(secret) {
+		return fmt.Errorf("token share has %d bytes, want %d", len(tokenShare), len(secret))
+	}
+	share := xorBytes(secret, tokenShare)
+	defer clear(share)
+	return f.Set(passphrase, share)
+}
+
+// GetSplit decrypts the key from f using the given passphrase and combines it
+// with tokenShare to recover a secret stored by SetSplit. It reports
+// ErrSecretWrongSize if tokenShare does not have the length of the secret.
+//
+// A wrong tokenShare of the correct length cannot be detected, and yields
+// the wrong secret.
+func (f *File) GetSplit(passphrase string, tokenShare []byte) ([]byte, error) {
+	share, err := f.Get(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(share)
+	if len(share) != len(tokenShare) {
+		return nil, fmt.Errorf("%w: token share has %d bytes, want %d",
+			ErrSecretWrongSize, len(tokenShare), len(share))
+	}
+	return xorBytes(share, tokenShare), nil
+}
+
+// xorBytes returns a new slice containing the XOR of a and b, which must have
+// the same length.
+func xorBytes(a, b []byte) []byte {
+	out := make([]byte, len(a))
+	subtle.XORBytes(out, a, b)
+	return out
+}
@@ -0,0 +1,119 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package keyfile_test
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"errors"
+	"io"
+	mrand "math/rand"
+	"testing"
+
+	"github.com/creachadair/keyfile"
+	"github.com/creachadair/mds/mtest"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSplitCombine(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20241016084416)))
+	const passphrase = "divide and conquer"
+
+	f := keyfile.New()
+	key, err := f.Random(passphrase, 32)
+	if err != nil {
+		t.Fatalf("Random(32) failed: %v", err)
+	}
+	if _, err := f.Split("wrong", 5, 3); err == nil {
+		t.Error("Split with wrong passphrase: got nil, want error")
+	}
+	shares, err := f.Split(passphrase, 5, 3)
+	if err != nil {
+		t.Fatalf("Split(5, 3): unexpected error: %v", err)
+	} else if len(shares) != 5 {
+		t.Fatalf("Split(5, 3): got %d shares, want 5", len(shares))
+	}
+
+	// Any three shares, in any order, recover the key.
+	for _, idx := range [][]int{{0, 1, 2}, {4, 2, 0}, {3, 1, 4, 0}} {
+		var sub [][]byte
+		for _, i := range idx {
+			sub = append(sub, shares[i])
+		}
+		got, err := keyfile.Combine(sub)
+		if err != nil {
+			t.Errorf("Combine %v: unexpected error: %v", idx, err)
+		} else if diff := cmp.Diff(key, got); diff != "" {
+			t.Errorf("Combine %v: wrong key (-want, +got):\n%s", idx, diff)
+		}
+	}
+
+	if got, err := keyfile.Combine(shares[:2]); !errors.Is(err, keyfile.ErrTooFewShares) {
+		t.Errorf("Combine (2 shares): got %x, %v; want %v", got, err, keyfile.ErrTooFewShares)
+	}
+
+	// Shares from a different split are not compatible.
+	other, err := f.Split(passphrase, 3, 2)
+	if err != nil {
+		t.Fatalf("Split(3, 2): unexpected error: %v", err)
+	}
+	if got, err := keyfile.Combine([][]byte{shares[0], shares[1], other[2]}); !errors.Is(err, keyfile.ErrBadShare) {
+		t.Errorf("Combine (mixed): got %x, %v; want %v", got, err, keyfile.ErrBadShare)
+	}
+	same, err := f.Split(passphrase, 5, 3)
+	if err != nil {
+		t.Fatalf("Split(5, 3): unexpected error: %v", err)
+	}
+	if got, err := keyfile.Combine([][]byte{shares[0], shares[1], same[2]}); !errors.Is(err, keyfile.ErrBadShare) {
+		t.Errorf("Combine (mixed, same threshold): got %x, %v; want %v", got, err, keyfile.ErrBadShare)
+	}
+
+	// A share whose value was altered, or that was relabeled with the ID of
+	// another split, yields a secret that does not match the checksum.
+	bad := bytes.Clone(shares[2])
+	bad[len(bad)-2] ^= 1
+	if got, err := keyfile.Combine([][]byte{shares[0], shares[1], bad}); !errors.Is(err, keyfile.ErrBadShare) {
+		t.Errorf("Combine (altered): got %x, %v; want %v", got, err, keyfile.ErrBadShare)
+	}
+	relabeled := append(bytes.Clone(shares[2][:20]), same[2][20:]...)
+	if got, err := keyfile.Combine([][]byte{shares[0], shares[1], relabeled}); !errors.Is(err, keyfile.ErrBadShare) {
+		t.Errorf("Combine (relabeled): got %x, %v; want %v", got, err, keyfile.ErrBadShare)
+	}
+	if got, err := keyfile.Combine([][]byte{shares[0], shares[1], []byte("bogus")}); !errors.Is(err, keyfile.ErrBadShare) {
+		t.Errorf("Combine (malformed): got %x, %v; want %v", got, err, keyfile.ErrBadShare)
+	}
+}
+
+func TestSetGetSplit(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20241016094358)))
+	const passphrase = "two heads are better"
+	secret := []byte("the crown jewels")
+	token := []byte("0123456789abcdef")
+
+	f := keyfile.New()
+	if err := f.SetSplit(passphrase, secret, token[:4]); err == nil {
+		t.Error("SetSplit with short token share: got nil, want error")
+	}
+	if err := f.SetSplit(passphrase, secret, token); err != nil {
+		t.Fatalf("SetSplit: unexpected error: %v", err)
+	}
+
+	// The file alone does not hold the secret.
+	if got, err := f.Get(passphrase); err != nil {
+		t.Errorf("Get: unexpected error: %v", err)
+	} else if string(got) == string(secret) {
+		t.Errorf("Get: got the secret %q without the token share", got)
+	}
+
+	if got, err := f.GetSplit(passphrase, token); err != nil {
+		t.Errorf("GetSplit: unexpected error: %v", err)
+	} else if diff := cmp.Diff(secret, got); diff != "" {
+		t.Errorf("GetSplit: wrong secret (-want, +got):\n%s", diff)
+	}
+	if got, err := f.GetSplit(passphrase, token[1:]); !errors.Is(err, keyfile.ErrSecretWrongSize) {
+		t.Errorf("GetSplit (short share): got %q, %v; want %v", got, err, keyfile.ErrSecretWrongSize)
+	}
+	if got, err := f.GetSplit("wrong", token); err == nil {
+		t.Errorf("GetSplit (wrong passphrase): got %q, want error", got)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package keyfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrNoSuchKey is reported by Store.Get when the store has no entry with the
+// requested name, and by an Agent that does not hold the requested key.
+var ErrNoSuchKey = errors.New("no such key")
+
+// storeMagic is the format tag for an encoded Store.
+//
+// An encoded store is structured as follows:
+//
+//	Len      Description
+//	3        Format tag, "KM\x01" == "\x4b\x4d\x01"
+//	varint   Number of entries (n)
+//
+// followed by n entries in increasing order of name, each structured as:
+//
+//	Len      Description
+//	varint   Length of the name in bytes (nlen)
+//	nlen     Name
+//	varint   Length of the packet in bytes (plen)
+//	plen     Keyfile packet, as produced by File.Encode
+//
+// Lengths and counts are unsigned varints as defined by encoding/binary.
+const storeMagic = "KM\x01"
+
+// A Store is a collection of secrets identified by name, each stored in its
+// own keyfile packet. Entries are encrypted independently, and may use
+// different passphrases. A zero value is ready for use.
+//
+// The concurrency rules for a Store are the same as for a File.
+type Store struct {
+	entries map[string]*File
+}
+
+// NewStore creates a new empty *Store.
+func NewStore() *Store { return new(Store) }
+
+// ParseStore parses a binary store packet into a *Store. It reports
+// ErrBadPacket if the packet is malformed, if any entry is not a valid
+// keyfile packet, or if a name occurs more than once.
+func ParseStore(data []byte) (*Store, error) {
+	if !bytes.HasPrefix(data, []byte(storeMagic)) {
+		return nil, fmt.Errorf("%w: invalid store magic", ErrBadPacket)
+	}
+	data = data[len(storeMagic):]
+	next := func() ([]byte, bool) {
+		n, nb := binary.Uvarint(data)
+		if nb <= 0 || n > uint64(len(data)-nb) {
+			return nil, false
+		}
+		field := data[nb : nb+int(n)]
+		data = data[nb+int(n):]
+		return field, true
+	}
+
+	count, nb := binary.Uvarint(data)
+	if nb <= 0 {
+		return nil, fmt.Errorf("%w: invalid entry count", ErrBadPacket)
+	}
+	data = data[nb:]
+	s := &Store{entries: make(map[string]*File)}
+	for i := uint64(0); i < count; i++ {
+		name, ok := next()
+		if !ok || len(name) == 0 {
+			return nil, fmt.Errorf("%w: invalid name for entry %d", ErrBadPacket, i+1)
+		} else if _, ok := s.entries[string(name)]; ok {
+			return nil, fmt.Errorf("%w: duplicate entry %q", ErrBadPacket, name)
+		}
+		pkt, ok := next()
+		if !ok {
+			return nil, fmt.Errorf("%w: invalid packet for entry %q", ErrBadPacket, name)
+		}
+		f, err := Parse(pkt)
+		if err != nil {
+			return nil, fmt.Errorf("entry %q: %w", name, err)
+		}
+		s.entries[string(name)] = f
+	}
+	if len(data) != 0 {
+		return nil, fmt.Errorf("%w: extra data after %d entries", ErrBadPacket, count)
+	}
+	return s, nil
+}
+
+// Encode encodes s in binary format for storage, such that
+// keyfile.ParseStore(s.Encode()) is equivalent to s.
+func (s *Store) Encode() []byte {
+	names := s.Names()
+	buf := binary.AppendUvarint([]byte(storeMagic), uint64(len(names)))
+	for _, name := range names {
+		pkt := s.entries[name].Encode()
+		buf = binary.AppendUvarint(buf, uint64(len(name)))
+		buf = append(buf, name...)
+		buf = binary.AppendUvarint(buf, uint64(len(pkt)))
+		buf = append(buf, pkt...)
+	}
+	return buf
+}
+
+// Names returns the names of the entries in s, in increasing order.
+func (s *Store) Names() []string {
+	names := make([]string, 0, len(s.entries))
+	for name := range s.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get decrypts and returns the secret stored under name using the given
+// passphrase. It returns ErrNoSuchKey if s has no entry with that name.
+func (s *Store) Get(name, passphrase string) ([]byte, error) {
+	f, ok := s.entries[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrNoSuchKey, name)
+	}
+	return f.Get(passphrase)
+}
+
+// Set encrypts the secret with the passphrase and stores it under name,
+// replacing any previous entry with that name. The name must be non-empty.
+func (s *Store) Set(name, passphrase string, secret []byte) error {
+	if name == "" {
+		return errors.New("empty entry name")
+	}
+	var f File
+	if err := f.Set(passphrase, secret); err != nil {
+		return err
+	}
+	if s.entries == nil {
+		s.entries = make(map[string]*File)
+	}
+	s.entries[name] = &f
+	return nil
+}
+
+// Delete removes the entry with the given name from s, and reports whether
+// such an entry was present.
+func (s *Store) Delete(name string) bool {
+	_, ok := s.entries[name]
+	delete(s.entries, name)
+	return ok
+}
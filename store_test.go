@@ -0,0 +1,89 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package keyfile_test
+
+import (
+	crand "crypto/rand"
+	"errors"
+	"io"
+	mrand "math/rand"
+	"testing"
+
+	"github.com/creachadair/keyfile"
+	"github.com/creachadair/mds/mtest"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStore(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20241019110207)))
+	entries := []struct {
+		name, passphrase, secret string
+	}{
+		{"signing", "alpha", "signing key"},
+		{"api", "bravo", "api token"},
+		{"db", "alpha", "database password"},
+	}
+
+	s := keyfile.NewStore()
+	for _, e := range entries {
+		if err := s.Set(e.name, e.passphrase, []byte(e.secret)); err != nil {
+			t.Fatalf("Set %q: unexpected error: %v", e.name, err)
+		}
+	}
+	if err := s.Set("", "x", []byte("y")); err == nil {
+		t.Error("Set with empty name: got nil, want error")
+	}
+
+	// Round-trip the store through its encoding.
+	s, err := keyfile.ParseStore(s.Encode())
+	if err != nil {
+		t.Fatalf("ParseStore: unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"api", "db", "signing"}, s.Names()); diff != "" {
+		t.Errorf("Names (-want, +got):\n%s", diff)
+	}
+	for _, e := range entries {
+		if got, err := s.Get(e.name, e.passphrase); err != nil {
+			t.Errorf("Get %q: unexpected error: %v", e.name, err)
+		} else if string(got) != e.secret {
+			t.Errorf("Get %q: got %q, want %q", e.name, got, e.secret)
+		}
+	}
+	if got, err := s.Get("api", "alpha"); err == nil {
+		t.Errorf("Get api with wrong passphrase: got %q, want error", got)
+	}
+
+	if !s.Delete("db") {
+		t.Error("Delete db: got false, want true")
+	}
+	if s.Delete("db") {
+		t.Error("Delete db again: got true, want false")
+	}
+	if got, err := s.Get("db", "alpha"); !errors.Is(err, keyfile.ErrNoSuchKey) {
+		t.Errorf("Get db after Delete: got %q, %v; want %v", got, err, keyfile.ErrNoSuchKey)
+	}
+}
+
+func TestParseStoreErrors(t *testing.T) {
+	pkt := string(mustParse(t, 8).Encode())
+	entry := func(name string) string {
+		return string(rune(len(name))) + name + string(rune(len(pkt))) + pkt
+	}
+	for _, test := range []string{
+		"",                                       // missing magic
+		"KS\x01",                                 // wrong magic
+		"KM\x01",                                 // missing count
+		"KM\x01\x01",                             // missing entry
+		"KM\x01\x01\x00",                         // empty name
+		"KM\x01\x01\x03ab",                       // truncated name
+		"KM\x01\x01\x01a\x05KF",                  // truncated packet
+		"KM\x01\x01\x01a\x03KF\x09",              // invalid packet
+		"KM\x01\x02" + entry("a") + entry("a"),   // duplicate name
+		"KM\x01\x01" + entry("a") + "extra data", // trailing data
+	} {
+		s, err := keyfile.ParseStore([]byte(test))
+		if !errors.Is(err, keyfile.ErrBadPacket) {
+			t.Errorf("ParseStore(%q): got %v, %v; want %v", test, s, err, keyfile.ErrBadPacket)
+		}
+	}
+}
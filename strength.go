@@ -0,0 +1,82 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package keyfile
+
+import (
+	"math"
+	"unicode"
+)
+
+// StrongBits is the estimated entropy, in bits, at or above which
+// PassphraseStrength considers a passphrase strong.
+const StrongBits = 60
+
+// Strength is a coarse estimate of the strength of a passphrase, as reported
+// by PassphraseStrength.
+type Strength struct {
+	Length  int     // the number of characters in the passphrase
+	Classes int     // the number of character classes used (lower, upper, digit, symbol, other)
+	Bits    float64 // a rough estimate of the entropy of the passphrase in bits
+}
+
+// Strong reports whether s meets the StrongBits threshold.
+func (s Strength) Strong() bool { return s.Bits >= StrongBits }
+
+// PassphraseStrength returns a heuristic estimate of the strength of pp.
+//
+// The estimate assumes each character is drawn uniformly from the union of
+// the character classes that occur in pp, except that a character that
+// repeats or continues a run from its predecessor (as in "aaa" or "1234")
+// counts for only one bit. This is not a substitute for a real strength
+// meter, but it reliably flags short, repetitive, and single-class
+// passphrases.
+func PassphraseStrength(pp string) Strength {
+	const (
+		lower = 1 << iota
+		upper
+		digit
+		symbol
+		other
+	)
+	var classes, n int
+	var bits float64
+	var prev rune = -1
+	var runs int // characters continuing a run
+	for _, r := range pp {
+		n++
+		switch {
+		case r >= 'a' && r <= 'z':
+			classes |= lower
+		case r >= 'A' && r <= 'Z':
+			classes |= upper
+		case r >= '0' && r <= '9':
+			classes |= digit
+		case r < unicode.MaxASCII && unicode.IsPrint(r):
+			classes |= symbol
+		default:
+			classes |= other
+		}
+		if d := r - prev; d >= -1 && d <= 1 {
+			runs++
+		}
+		prev = r
+	}
+
+	var pool int
+	for _, c := range []struct{ class, size int }{
+		{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100},
+	} {
+		if classes&c.class != 0 {
+			pool += c.size
+		}
+	}
+	if pool > 0 {
+		bits = float64(n-runs)*math.Log2(float64(pool)) + float64(runs)
+	}
+
+	var nc int
+	for ; classes != 0; classes &= classes - 1 {
+		nc++
+	}
+	return Strength{Length: n, Classes: nc, Bits: bits}
+}
@@ -0,0 +1,33 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package keyfile_test
+
+import (
+	"testing"
+
+	"github.com/creachadair/keyfile"
+)
+
+func TestPassphraseStrength(t *testing.T) {
+	for _, test := range []struct {
+		input   string
+		length  int
+		classes int
+		strong  bool
+	}{
+		{"", 0, 0, false},
+		{"password", 8, 1, false},
+		{"aaaaaaaaaaaaaaaaaaaaaaaa", 24, 1, false},
+		{"123456789012345678901234", 24, 1, false},
+		{"Abc1!", 5, 4, false},
+		{"correct horse battery staple", 28, 2, true},
+		{"xK9#mQ2$vL7!pR4@", 16, 4, true},
+		{"ключ от всех дверей", 19, 2, true},
+	} {
+		got := keyfile.PassphraseStrength(test.input)
+		if got.Length != test.length || got.Classes != test.classes || got.Strong() != test.strong {
+			t.Errorf("PassphraseStrength(%q): got %+v (strong=%v), want length %d, classes %d, strong=%v",
+				test.input, got, got.Strong(), test.length, test.classes, test.strong)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package keyfile
+
+import "fmt"
+
+// A Wrapper encrypts a secret for export in another format, such as an age
+// file addressed to a set of recipients.
+type Wrapper interface {
+	Wrap(secret []byte) ([]byte, error)
+}
+
+// An Unwrapper decrypts a secret that was exported in another format.
+type Unwrapper interface {
+	Unwrap(data []byte) ([]byte, error)
+}
+
+// Export decrypts the key from f using the given passphrase and returns the
+// result of wrapping it with w. This allows a key to be shared with a
+// recipient who does not know the passphrase.
+func (f *File) Export(passphrase string, w Wrapper) ([]byte, error) {
+	key, err := f.Get(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(key)
+	out, err := w.Wrap(key)
+	if err != nil {
+		return nil, fmt.Errorf("wrap: %w", err)
+	}
+	return out, nil
+}
+
+// Import unwraps a secret from data using u, encrypts it with the passphrase,
+// and stores it in f, replacing any previous data. It is the inverse of
+// Export.
+func (f *File) Import(passphrase string, data []byte, u Unwrapper) error {
+	key, err := u.Unwrap(data)
+	if err != nil {
+		return fmt.Errorf("unwrap: %w", err)
+	}
+	defer clear(key)
+	return f.Set(passphrase, key)
+}
@@ -0,0 +1,73 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package keyfile_test
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"errors"
+	"io"
+	mrand "math/rand"
+	"testing"
+
+	"github.com/creachadair/keyfile"
+	"github.com/creachadair/mds/mtest"
+	"github.com/google/go-cmp/cmp"
+)
+
+// rot13 is a toy Wrapper and Unwrapper for testing.
+type rot13 struct{}
+
+func (rot13) Wrap(secret []byte) ([]byte, error) {
+	return append([]byte("rot13:"), bytes.Map(rot, secret)...), nil
+}
+
+func (rot13) Unwrap(data []byte) ([]byte, error) {
+	rest, ok := bytes.CutPrefix(data, []byte("rot13:"))
+	if !ok {
+		return nil, errors.New("not wrapped")
+	}
+	return bytes.Map(rot, rest), nil
+}
+
+func rot(r rune) rune {
+	switch {
+	case r >= 'a' && r <= 'z':
+		return 'a' + (r-'a'+13)%26
+	case r >= 'A' && r <= 'Z':
+		return 'A' + (r-'A'+13)%26
+	}
+	return r
+}
+
+func TestExportImport(t *testing.T) {
+	mtest.Swap[io.Reader](t, &crand.Reader, mrand.New(mrand.NewSource(20241016100211)))
+	const secret = "Hello, World"
+
+	f := keyfile.New()
+	if err := f.Set("export", []byte(secret)); err != nil {
+		t.Fatalf("Set: unexpected error: %v", err)
+	}
+	wrapped, err := f.Export("export", rot13{})
+	if err != nil {
+		t.Fatalf("Export: unexpected error: %v", err)
+	} else if got, want := string(wrapped), "rot13:Uryyb, Jbeyq"; got != want {
+		t.Errorf("Export: got %q, want %q", got, want)
+	}
+	if _, err := f.Export("wrong", rot13{}); err == nil {
+		t.Error("Export with wrong passphrase: got nil, want error")
+	}
+
+	g := keyfile.New()
+	if err := g.Import("import", wrapped, rot13{}); err != nil {
+		t.Fatalf("Import: unexpected error: %v", err)
+	}
+	if got, err := g.Get("import"); err != nil {
+		t.Errorf("Get: unexpected error: %v", err)
+	} else if diff := cmp.Diff([]byte(secret), got); diff != "" {
+		t.Errorf("Get after Import (-want, +got):\n%s", diff)
+	}
+	if err := g.Import("import", []byte("bogus"), rot13{}); err == nil {
+		t.Error("Import of invalid data: got nil, want error")
+	}
+}